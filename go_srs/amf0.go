@@ -0,0 +1,296 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// AMF0 marker, @see: 2.1 Types Overview of amf0 spec.
+const SRS_AMF0_NUMBER = 0x00
+const SRS_AMF0_BOOLEAN = 0x01
+const SRS_AMF0_STRING = 0x02
+const SRS_AMF0_OBJECT = 0x03
+const SRS_AMF0_NULL = 0x05
+const SRS_AMF0_UNDEFINED = 0x06
+const SRS_AMF0_ECMA_ARRAY = 0x08
+const SRS_AMF0_OBJECT_END = 0x09
+const SRS_AMF0_STRICT_ARRAY = 0x0A
+const SRS_AMF0_DATE = 0x0B
+const SRS_AMF0_LONG_STRING = 0x0C
+
+// the max depth of nested object and array, the decoder is recursive,
+// so a malicious message with deep nested values overflows the stack.
+const SRS_AMF0_MAX_DEPTH = 32
+
+var srs_amf0_eof = errors.New("amf0 data not enough")
+
+/**
+* the amf0 decoder, to decode the data message, for example, onMetaData.
+* the number is decoded to float64, the string to string, the boolean to bool,
+* the object and ecma array to map[string]interface{},
+* the strict array to []interface{}, the null and undefined to nil.
+*/
+type SrsAmf0Decoder struct {
+	data []byte
+	pos int
+	// the depth of the nested object and array.
+	depth int
+}
+func NewSrsAmf0Decoder(data []byte) (*SrsAmf0Decoder) {
+	return &SrsAmf0Decoder{data:data}
+}
+/**
+* the bytes decoded.
+*/
+func (r *SrsAmf0Decoder) Pos() (int) {
+	return r.pos
+}
+func (r *SrsAmf0Decoder) Empty() (bool) {
+	return r.pos >= len(r.data)
+}
+func (r *SrsAmf0Decoder) require(n int) (err error) {
+	if r.pos + n > len(r.data) {
+		return srs_amf0_eof
+	}
+	return
+}
+/**
+* read an amf0 string, for example, the command name.
+*/
+func (r *SrsAmf0Decoder) ReadString() (v string, err error) {
+	if err = r.require(1); err != nil {
+		return
+	}
+	if r.data[r.pos] != SRS_AMF0_STRING {
+		return "", errors.New("amf0 string marker invalid")
+	}
+	r.pos++
+	return r.read_utf8(2)
+}
+/**
+* read any amf0 value.
+*/
+func (r *SrsAmf0Decoder) ReadAny() (v interface{}, err error) {
+	if err = r.require(1); err != nil {
+		return
+	}
+	marker := r.data[r.pos]
+	r.pos++
+
+	// the object and array nest the values, limit the depth.
+	if marker == SRS_AMF0_OBJECT || marker == SRS_AMF0_ECMA_ARRAY || marker == SRS_AMF0_STRICT_ARRAY {
+		if r.depth >= SRS_AMF0_MAX_DEPTH {
+			return nil, SrsError{code:ERROR_CODEC_AMF0_TOO_DEEP, desc:fmt.Sprintf("amf0 nested over %v levels", SRS_AMF0_MAX_DEPTH)}
+		}
+		r.depth++
+		defer func() {
+			r.depth--
+		}()
+	}
+
+	switch marker {
+	case SRS_AMF0_NUMBER:
+		if err = r.require(8); err != nil {
+			return
+		}
+		v = math.Float64frombits(binary.BigEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+	case SRS_AMF0_BOOLEAN:
+		if err = r.require(1); err != nil {
+			return
+		}
+		v = r.data[r.pos] != 0
+		r.pos++
+	case SRS_AMF0_STRING:
+		v, err = r.read_utf8(2)
+	case SRS_AMF0_LONG_STRING:
+		v, err = r.read_utf8(4)
+	case SRS_AMF0_NULL, SRS_AMF0_UNDEFINED:
+		v = nil
+	case SRS_AMF0_OBJECT:
+		v, err = r.read_properties()
+	case SRS_AMF0_ECMA_ARRAY:
+		// the count is not reliable, read to the object end.
+		if err = r.require(4); err != nil {
+			return
+		}
+		r.pos += 4
+		v, err = r.read_properties()
+	case SRS_AMF0_STRICT_ARRAY:
+		if err = r.require(4); err != nil {
+			return
+		}
+		count := int(binary.BigEndian.Uint32(r.data[r.pos:]))
+		r.pos += 4
+
+		arr := []interface{}{}
+		for i := 0; i < count; i++ {
+			var e interface{}
+			if e, err = r.ReadAny(); err != nil {
+				return
+			}
+			arr = append(arr, e)
+		}
+		v = arr
+	case SRS_AMF0_DATE:
+		// the date in ms and the timezone.
+		if err = r.require(10); err != nil {
+			return
+		}
+		v = math.Float64frombits(binary.BigEndian.Uint64(r.data[r.pos:]))
+		r.pos += 10
+	default:
+		err = errors.New("amf0 marker not supported")
+	}
+	return
+}
+func (r *SrsAmf0Decoder) read_utf8(size_bytes int) (v string, err error) {
+	if err = r.require(size_bytes); err != nil {
+		return
+	}
+	var n int
+	if size_bytes == 2 {
+		n = int(binary.BigEndian.Uint16(r.data[r.pos:]))
+	} else {
+		n = int(binary.BigEndian.Uint32(r.data[r.pos:]))
+	}
+	r.pos += size_bytes
+
+	if err = r.require(n); err != nil {
+		return
+	}
+	v = string(r.data[r.pos:r.pos + n])
+	r.pos += n
+	return
+}
+func (r *SrsAmf0Decoder) read_properties() (v map[string]interface{}, err error) {
+	v = map[string]interface{}{}
+	for {
+		// the object end is an empty name and the end marker.
+		if err = r.require(3); err != nil {
+			return
+		}
+		if r.data[r.pos] == 0 && r.data[r.pos + 1] == 0 && r.data[r.pos + 2] == SRS_AMF0_OBJECT_END {
+			r.pos += 3
+			return
+		}
+
+		var name string
+		if name, err = r.read_utf8(2); err != nil {
+			return
+		}
+		if v[name], err = r.ReadAny(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/**
+* the amf0 encoder, to encode the call message, for example, the bandcheck.
+* the value to write can be float64, int, string, bool, nil,
+* map[string]interface{} as object, and []interface{} as strict array.
+*/
+type SrsAmf0Encoder struct {
+	buf bytes.Buffer
+}
+func NewSrsAmf0Encoder() (*SrsAmf0Encoder) {
+	return &SrsAmf0Encoder{}
+}
+func (r *SrsAmf0Encoder) Bytes() ([]byte) {
+	return r.buf.Bytes()
+}
+/**
+* write any amf0 value.
+*/
+func (r *SrsAmf0Encoder) WriteAny(v interface{}) (err error) {
+	switch v := v.(type) {
+	case nil:
+		r.buf.WriteByte(SRS_AMF0_NULL)
+	case bool:
+		r.buf.WriteByte(SRS_AMF0_BOOLEAN)
+		if v {
+			r.buf.WriteByte(1)
+		} else {
+			r.buf.WriteByte(0)
+		}
+	case int:
+		return r.WriteAny(float64(v))
+	case int64:
+		return r.WriteAny(float64(v))
+	case uint64:
+		return r.WriteAny(float64(v))
+	case float64:
+		r.buf.WriteByte(SRS_AMF0_NUMBER)
+		binary.Write(&r.buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		if len(v) > 0xffff {
+			r.buf.WriteByte(SRS_AMF0_LONG_STRING)
+			r.write_utf8(v, 4)
+		} else {
+			r.buf.WriteByte(SRS_AMF0_STRING)
+			r.write_utf8(v, 2)
+		}
+	case map[string]interface{}:
+		r.buf.WriteByte(SRS_AMF0_OBJECT)
+
+		// sort the properties, to encode in order.
+		names := []string{}
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			r.write_utf8(name, 2)
+			if err = r.WriteAny(v[name]); err != nil {
+				return
+			}
+		}
+		r.buf.Write([]byte{ 0, 0, SRS_AMF0_OBJECT_END })
+	case []interface{}:
+		r.buf.WriteByte(SRS_AMF0_STRICT_ARRAY)
+		binary.Write(&r.buf, binary.BigEndian, uint32(len(v)))
+		for _, e := range v {
+			if err = r.WriteAny(e); err != nil {
+				return
+			}
+		}
+	default:
+		return errors.New("amf0 type not supported")
+	}
+	return
+}
+func (r *SrsAmf0Encoder) write_utf8(v string, size_bytes int) {
+	if size_bytes == 2 {
+		binary.Write(&r.buf, binary.BigEndian, uint16(len(v)))
+	} else {
+		binary.Write(&r.buf, binary.BigEndian, uint32(len(v)))
+	}
+	r.buf.WriteString(v)
+}
@@ -0,0 +1,97 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAmf0DecodeAny(t *testing.T) {
+	enc := NewSrsAmf0Encoder()
+	obj := map[string]interface{}{
+		"width": 1280.0, "codec": "avc1", "stereo": true, "null": nil,
+		"arr": []interface{}{ 1.0, "two" },
+	}
+	if err := enc.WriteAny(obj); err != nil {
+		t.Fatalf("encode failed, err=%v", err)
+	}
+
+	v, err := NewSrsAmf0Decoder(enc.Bytes()).ReadAny()
+	if err != nil {
+		t.Fatalf("decode failed, err=%v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || m["width"] != 1280.0 || m["codec"] != "avc1" || m["stereo"] != true || m["null"] != nil {
+		t.Errorf("decoded %v invalid", v)
+	}
+	if arr, ok := m["arr"].([]interface{}); !ok || len(arr) != 2 || arr[1] != "two" {
+		t.Errorf("decoded array %v invalid", m["arr"])
+	}
+}
+
+func TestAmf0DecodeDepth(t *testing.T) {
+	// the nested value of each level, with the empty name for object and ecma array,
+	// and the end of each level, to build a complete value.
+	cases := []struct {
+		name string
+		level []byte
+		end []byte
+	}{
+		{"object", []byte{ SRS_AMF0_OBJECT, 0x00, 0x00 }, []byte{ 0x00, 0x00, SRS_AMF0_OBJECT_END }},
+		{"ecma array", []byte{ SRS_AMF0_ECMA_ARRAY, 0, 0, 0, 1, 0x00, 0x00 }, []byte{ 0x00, 0x00, SRS_AMF0_OBJECT_END }},
+		{"strict array", []byte{ SRS_AMF0_STRICT_ARRAY, 0, 0, 0, 1 }, nil},
+	}
+
+	for _, c := range cases {
+		build := func(depth int) ([]byte) {
+			data := bytes.Repeat(c.level, depth)
+			data = append(data, SRS_AMF0_NULL)
+			return append(data, bytes.Repeat(c.end, depth)...)
+		}
+
+		if _, err := NewSrsAmf0Decoder(build(SRS_AMF0_MAX_DEPTH)).ReadAny(); err != nil {
+			t.Errorf("%v: decode %v levels failed, err=%v", c.name, SRS_AMF0_MAX_DEPTH, err)
+		}
+
+		_, err := NewSrsAmf0Decoder(build(SRS_AMF0_MAX_DEPTH + 1)).ReadAny()
+		if se, ok := err.(SrsError); !ok || se.code != ERROR_CODEC_AMF0_TOO_DEEP {
+			t.Errorf("%v: decode %v levels should fail, err=%v", c.name, SRS_AMF0_MAX_DEPTH + 1, err)
+		}
+
+		// the malicious message without end, which overflows the stack without limit.
+		_, err = NewSrsAmf0Decoder(bytes.Repeat(c.level, 1024 * 1024)).ReadAny()
+		if se, ok := err.(SrsError); !ok || se.code != ERROR_CODEC_AMF0_TOO_DEEP {
+			t.Errorf("%v: decode deep nested should fail, err=%v", c.name, err)
+		}
+	}
+}
+
+func TestAmf0DecodeMetadataDepth(t *testing.T) {
+	enc := NewSrsAmf0Encoder()
+	enc.WriteAny("onMetaData")
+	data := append(enc.Bytes(), bytes.Repeat([]byte{ SRS_AMF0_OBJECT, 0x00, 0x01, 'a' }, 1024 * 1024)...)
+
+	if _, _, err := srs_codec_decode_metadata(data); err == nil {
+		t.Errorf("decode deep nested metadata should fail")
+	}
+}
@@ -0,0 +1,342 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+// the bandcheck call between server and client, @see: srs bandwidth check.
+// server start the play bytes, client response starting play bytes.
+const SRS_BW_CHECK_START_PLAY = "onSrsBandCheckStartPlayBytes"
+const SRS_BW_CHECK_STARTING_PLAY = "onSrsBandCheckStartingPlayBytes"
+// server send the play bytes.
+const SRS_BW_CHECK_PLAYING = "onSrsBandCheckPlaying"
+// server stop the play bytes, client response stopped play bytes.
+const SRS_BW_CHECK_STOP_PLAY = "onSrsBandCheckStopPlayBytes"
+const SRS_BW_CHECK_STOPPED_PLAY = "onSrsBandCheckStoppedPlayBytes"
+// server start the publish bytes, client response starting publish bytes.
+const SRS_BW_CHECK_START_PUBLISH = "onSrsBandCheckStartPublishBytes"
+const SRS_BW_CHECK_STARTING_PUBLISH = "onSrsBandCheckStartingPublishBytes"
+// client send the publish bytes.
+const SRS_BW_CHECK_PUBLISHING = "onSrsBandCheckPublishing"
+// server stop the publish bytes, client response stopped publish bytes.
+const SRS_BW_CHECK_STOP_PUBLISH = "onSrsBandCheckStopPublishBytes"
+const SRS_BW_CHECK_STOPPED_PUBLISH = "onSrsBandCheckStoppedPublishBytes"
+// server send the result, client response the final packet.
+const SRS_BW_CHECK_FINISHED = "onSrsBandCheckFinished"
+const SRS_BW_CHECK_FINAL = "finalClientPacket"
+
+// the size of each play bytes packet.
+const SRS_BW_CHECK_PLAYING_BYTES = 4096
+
+// the message type of amf0 command, @see: 7.1.1. Command Message (20, 17) of rtmp spec.
+const SRS_RTMP_MSG_AMF0_COMMAND = 20
+
+/**
+* the last bandcheck time of each ip, to limit the bandcheck of client.
+*/
+var bandcheck_last_time map[string]time.Time = map[string]time.Time{}
+var bandcheck_last_time_lock *sync.Mutex = &sync.Mutex{}
+
+/**
+* the bandwidth check for the client which connect to the bandcheck vhost,
+* the server play bytes to client then client publish bytes to server,
+* measure the kbps of both direction and send the result to client.
+*/
+type SrsBandwidth struct {
+	client *SrsClient
+	conf *SrsConfBandcheck
+}
+func NewSrsBandwidth(client *SrsClient) (*SrsBandwidth) {
+	r := &SrsBandwidth{}
+	r.client = client
+	r.conf = client.vhost.bandcheck
+	return r
+}
+
+// interface for Log
+func (r *SrsBandwidth) GetId() (SrsLogId) {
+	return r.client.GetId()
+}
+func (r *SrsBandwidth) GetTag() (SrsLogTag) {
+	return "bandwidth"
+}
+func (r *SrsBandwidth) GetLogVhost() (*SrsConfVhost) {
+	return r.client.GetLogVhost()
+}
+
+/**
+* do the bandwidth check, reject the client when key is invalid,
+* or the client check too frequently.
+*/
+func (r *SrsBandwidth) BandwidthCheck() (err error) {
+	if key := r.client.query.Get("key"); key != r.conf.key {
+		err = SrsError{code:ERROR_SYSTEM_BANDWIDTH_KEY, desc:fmt.Sprintf("invalid bandcheck key %v", key)}
+		r.client.rtmp.ResponseConnectReject(r.client.req, "bandcheck key invalid")
+		return
+	}
+
+	ip := r.client.Ip()
+	if err = r.check_interval(ip); err != nil {
+		r.client.rtmp.ResponseConnectReject(r.client.req, "bandcheck rejected")
+		return
+	}
+
+	if err = r.client.response_connect_app(); err != nil {
+		return
+	}
+	if err = r.client.set_chunk_size(); err != nil {
+		return
+	}
+
+	SrsTrace(r, r, "start bandcheck, ip=%v, duration=%vms, limit=%vkbps", ip, r.conf.duration_ms, r.conf.limit_kbps)
+
+	start_time := time.Now()
+
+	var play_bytes, publish_bytes uint64
+	var play_time, publish_time time.Duration
+	if play_bytes, play_time, err = r.check_play(); err != nil {
+		return
+	}
+	if publish_bytes, publish_time, err = r.check_publish(); err != nil {
+		return
+	}
+
+	end_time := time.Now()
+	play_kbps, publish_kbps := srs_bandwidth_kbps(play_bytes, play_time), srs_bandwidth_kbps(publish_bytes, publish_time)
+	SrsTrace(r, r, "bandcheck finished, play=%vkbps(%vB in %v), publish=%vkbps(%vB in %v)",
+		play_kbps, play_bytes, play_time, publish_kbps, publish_bytes, publish_time)
+
+	// send the result to client.
+	if err = r.call(SRS_BW_CHECK_FINISHED, map[string]interface{}{
+		"code": 0,
+		"start_time": start_time.UnixNano() / int64(time.Millisecond),
+		"end_time": end_time.UnixNano() / int64(time.Millisecond),
+		"play_kbps": play_kbps,
+		"publish_kbps": publish_kbps,
+		"play_bytes": play_bytes,
+		"publish_bytes": publish_bytes,
+		"play_time": int64(play_time / time.Millisecond),
+		"publish_time": int64(publish_time / time.Millisecond),
+	}); err != nil {
+		return
+	}
+
+	// the client may close without final packet.
+	if err = r.expect(SRS_BW_CHECK_FINAL); err != nil {
+		SrsTrace(r, r, "ignore the final packet err=%v", err)
+		err = nil
+	}
+	return
+}
+/**
+* check whether the ip bandcheck too frequently.
+*/
+func (r *SrsBandwidth) check_interval(ip string) (err error) {
+	bandcheck_last_time_lock.Lock()
+	defer bandcheck_last_time_lock.Unlock()
+
+	now := time.Now()
+	interval := time.Duration(r.conf.interval_ms) * time.Millisecond
+
+	if last, ok := bandcheck_last_time[ip]; ok && now.Sub(last) < interval {
+		return SrsError{code:ERROR_SYSTEM_BANDWIDTH_DENIED, desc:fmt.Sprintf(
+			"ip %v bandcheck too frequently, last=%v, interval=%v", ip, last.Format("2006-01-02 15:04:05"), interval)}
+	}
+
+	// cleanup the expired ips.
+	for k, v := range bandcheck_last_time {
+		if now.Sub(v) >= interval {
+			delete(bandcheck_last_time, k)
+		}
+	}
+
+	bandcheck_last_time[ip] = now
+	return
+}
+/**
+* send bytes to client in duration, limit by the limit_kbps.
+*/
+func (r *SrsBandwidth) check_play() (bytes uint64, elapse time.Duration, err error) {
+	duration := time.Duration(r.conf.duration_ms) * time.Millisecond
+	args := map[string]interface{}{
+		"duration_ms": r.conf.duration_ms,
+		"interval_ms": 0,
+		"limit_kbps": r.conf.limit_kbps,
+	}
+
+	if err = r.call(SRS_BW_CHECK_START_PLAY, args); err != nil {
+		return
+	}
+	if err = r.expect(SRS_BW_CHECK_STARTING_PLAY); err != nil {
+		return
+	}
+	SrsTrace(r, r, "bandcheck start play bytes")
+
+	data := map[string]interface{}{
+		"data": strings.Repeat("srs band check data from server's playing......", SRS_BW_CHECK_PLAYING_BYTES / 48),
+	}
+
+	start := time.Now()
+	for elapse = 0; elapse < duration; elapse = time.Since(start) {
+		var n int
+		if n, err = r.call_bytes(SRS_BW_CHECK_PLAYING, data); err != nil {
+			return
+		}
+		bytes += uint64(n)
+
+		// sleep when exceed the limit kbps.
+		if r.conf.limit_kbps > 0 {
+			expect := time.Duration(bytes * 8 * uint64(time.Millisecond) / uint64(r.conf.limit_kbps))
+			if expect > time.Since(start) {
+				time.Sleep(expect - time.Since(start))
+			}
+		}
+	}
+
+	args["bytes_delta"] = bytes
+	args["duration_delta"] = int64(elapse / time.Millisecond)
+	if err = r.call(SRS_BW_CHECK_STOP_PLAY, args); err != nil {
+		return
+	}
+	if err = r.expect(SRS_BW_CHECK_STOPPED_PLAY); err != nil {
+		return
+	}
+	SrsTrace(r, r, "bandcheck stop play bytes, sent %vB in %v", bytes, elapse)
+	return
+}
+/**
+* recv bytes from client in duration.
+*/
+func (r *SrsBandwidth) check_publish() (bytes uint64, elapse time.Duration, err error) {
+	duration := time.Duration(r.conf.duration_ms) * time.Millisecond
+	args := map[string]interface{}{
+		"duration_ms": r.conf.duration_ms,
+		"interval_ms": 0,
+		"limit_kbps": r.conf.limit_kbps,
+	}
+
+	if err = r.call(SRS_BW_CHECK_START_PUBLISH, args); err != nil {
+		return
+	}
+	if err = r.expect(SRS_BW_CHECK_STARTING_PUBLISH); err != nil {
+		return
+	}
+	SrsTrace(r, r, "bandcheck start publish bytes")
+
+	// the client publish bytes until stop, never interrupt a message by the duration,
+	// for the protocol is broken when a chunk is partially read.
+	start := time.Now()
+	for elapse = 0; elapse < duration; elapse = time.Since(start) {
+		r.client.set_deadline(r.client.recv_timeout, -1)
+
+		var msg *rtmp.Message
+		if msg, err = r.client.rtmp.Protocol().RecvMessage(); err != nil {
+			return
+		}
+		bytes += uint64(len(msg.Payload))
+	}
+
+	args["bytes_delta"] = bytes
+	args["duration_delta"] = int64(elapse / time.Millisecond)
+	if err = r.call(SRS_BW_CHECK_STOP_PUBLISH, args); err != nil {
+		return
+	}
+	if err = r.expect(SRS_BW_CHECK_STOPPED_PUBLISH); err != nil {
+		return
+	}
+	SrsTrace(r, r, "bandcheck stop publish bytes, recv %vB in %v", bytes, elapse)
+	return
+}
+/**
+* call the client by name with args.
+*/
+func (r *SrsBandwidth) call(name string, args map[string]interface{}) (err error) {
+	_, err = r.call_bytes(name, args)
+	return
+}
+func (r *SrsBandwidth) call_bytes(name string, args map[string]interface{}) (n int, err error) {
+	enc := NewSrsAmf0Encoder()
+	for _, v := range []interface{}{ name, 0, nil, args } {
+		if err = enc.WriteAny(v); err != nil {
+			return
+		}
+	}
+	payload := enc.Bytes()
+
+	msg := &rtmp.Message{
+		Header: &rtmp.MessageHeader{ MessageType: SRS_RTMP_MSG_AMF0_COMMAND, PayloadLength: uint32(len(payload)) },
+		Payload: payload,
+	}
+
+	r.client.set_deadline(-1, r.client.send_timeout)
+	if err = r.client.rtmp.Protocol().SendMessage(msg, 0); err != nil {
+		return
+	}
+	return len(payload), nil
+}
+/**
+* recv the call of client by name, ignore the other messages.
+*/
+func (r *SrsBandwidth) expect(name string) (err error) {
+	for {
+		r.client.set_deadline(r.client.recv_timeout, -1)
+
+		var msg *rtmp.Message
+		if msg, err = r.client.rtmp.Protocol().RecvMessage(); err != nil {
+			return
+		}
+
+		payload := msg.Payload
+		if msg.Header.IsAmf3Command() && len(payload) > 0 {
+			payload = payload[1:]
+		} else if !msg.Header.IsAmf0Command() {
+			continue
+		}
+
+		var call string
+		if call, err = NewSrsAmf0Decoder(payload).ReadString(); err != nil {
+			return SrsError{code:ERROR_SYSTEM_BANDWIDTH_DENIED, desc:fmt.Sprintf("decode call failed, err=%v", err)}
+		}
+		if call == name {
+			return
+		}
+		SrsVerbose(r, r, "ignore the call %v, expect %v", call, name)
+	}
+	return
+}
+
+/**
+* calc the kbps of bytes in duration.
+*/
+func srs_bandwidth_kbps(bytes uint64, elapse time.Duration) (int) {
+	ms := int64(elapse / time.Millisecond)
+	if ms <= 0 {
+		return 0
+	}
+	return int(int64(bytes) * 8 / ms)
+}
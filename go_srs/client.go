@@ -22,17 +22,39 @@
 package main
 
 import (
+	"fmt"
 	"net"
+	"net/url"
+	"strings"
 	"io"
 	"github.com/winlinvip/go.rtmp/rtmp"
 	"time"
 	"os"
 	"runtime/pprof"
+	"sync"
+	"sync/atomic"
 )
 
 // default stream id for response the createStream request.
 const SRS_DEFAULT_SID = 1
 
+// the level and code of onStatus, @see: NetStatusEvent of flash.
+const SRS_STATUS_LEVEL_STATUS = "status"
+const SRS_STATUS_LEVEL_ERROR = "error"
+const SRS_STATUS_CODE_PUBLISH_BAD_NAME = "NetStream.Publish.BadName"
+const SRS_STATUS_CODE_PUBLISH_DENIED = "NetStream.Publish.Denied"
+const SRS_STATUS_CODE_PLAY_FAILED = "NetStream.Play.Failed"
+const SRS_STATUS_CODE_PAUSE_NOTIFY = "NetStream.Pause.Notify"
+const SRS_STATUS_CODE_UNPAUSE_NOTIFY = "NetStream.Unpause.Notify"
+
+// the phase of client, each phase has its timeout.
+const SRS_CLIENT_PHASE_HANDSHAKE = "handshake"
+const SRS_CLIENT_PHASE_CONNECT = "connect"
+const SRS_CLIENT_PHASE_IDENTIFY = "identify"
+const SRS_CLIENT_PHASE_BANDCHECK = "bandcheck"
+const SRS_CLIENT_PHASE_PUBLISH = "publish"
+const SRS_CLIENT_PHASE_PLAY = "play"
+
 /**
 * the response info for srs.
  */
@@ -45,6 +67,10 @@ func NewSrsResponse() (*SrsResponse) {
 	return r
 }
 
+// the clients by id, for the http api.
+var client_pool map[SrsLogId]*SrsClient = map[SrsLogId]*SrsClient{}
+var client_pool_lock *sync.Mutex = &sync.Mutex{}
+
 /**
 * the client provides the main logic control for RTMP clients.
 */
@@ -53,14 +79,45 @@ type SrsClient struct {
 	rtmp rtmp.Server
 	req *rtmp.Request
 	res *SrsResponse
+	// the query of app, for example, the key of bandcheck.
+	query url.Values
+	// the config of vhost, discovery after connect app.
+	vhost *SrsConfVhost
 	consumer *SrsConsumer
 	id SrsLogId
+	// whether the client is kicked, 1 for kicked.
+	kicked int32
+	// the lock for kick and deadline, never override the deadline of kick.
+	deadline_lock *sync.Mutex
+	// the current phase of client, for the timeout.
+	phase string
+	// whether the play client is paused.
+	paused bool
+	// the chunk size to send to client, 0 if not set.
+	chunk_size int
+	// the proxy to publish to origin, for the publish client of edge.
+	proxy *SrsEdgeProxy
+	// the current recv/send timeout, changed when paused.
+	recv_timeout time.Duration
+	send_timeout time.Duration
+	// the bytes and kbps of client.
+	kbps *SrsKbps
+	// the type, request and vhost of client for http api and log, copied
+	// when changed, for the request is modified by the client cycle.
+	stat_lock *sync.Mutex
+	stat_type string
+	stat_req rtmp.Request
+	stat_vhost *SrsConfVhost
+	stat_chunk_size int
 }
 func NewSrsClient(conn *net.TCPConn) (r *SrsClient, err error) {
 	r = &SrsClient{}
 	r.conn = conn
 	r.res = NewSrsResponse()
 	r.id = SrsGenerateId()
+	r.deadline_lock = &sync.Mutex{}
+	r.kbps = NewSrsKbps()
+	r.stat_lock = &sync.Mutex{}
 
 	if r.rtmp, err = rtmp.NewServer(conn); err != nil {
 		return
@@ -76,8 +133,129 @@ func (r *SrsClient) GetId() (SrsLogId) {
 func (r *SrsClient) GetTag() (SrsLogTag) {
 	return "client"
 }
+// the log is written by other goroutines, for example, the kick and hooks,
+// so use the copy of vhost and request.
+func (r *SrsClient) GetLogVhost() (*SrsConfVhost) {
+	r.stat_lock.Lock()
+	defer r.stat_lock.Unlock()
+
+	return r.stat_vhost
+}
+func (r *SrsClient) GetLogFields() (map[string]interface{}) {
+	r.stat_lock.Lock()
+	defer r.stat_lock.Unlock()
+
+	fields := map[string]interface{}{
+		"remote_addr": r.conn.RemoteAddr().String(),
+	}
+	if r.stat_req.Vhost != "" {
+		fields["vhost"] = r.stat_req.Vhost
+	}
+	if r.stat_req.App != "" {
+		fields["app"] = r.stat_req.App
+	}
+	if r.stat_req.Stream != "" {
+		fields["stream"] = r.stat_req.Stream
+	}
+	return fields
+}
+
+/**
+* kick the client, interrupt the io of client, then the
+* client cycle terminates and cleanup by the normal close path.
+*/
+func (r *SrsClient) Kick(reason string) {
+	r.deadline_lock.Lock()
+	defer r.deadline_lock.Unlock()
+
+	SrsTrace(r, r, "kick client, reason=%v", reason)
+	atomic.StoreInt32(&r.kicked, 1)
+	r.conn.SetDeadline(time.Now())
+}
+func (r *SrsClient) Kicked() (bool) {
+	return atomic.LoadInt32(&r.kicked) == 1
+}
+func (r *SrsClient) Ip() (string) {
+	ip, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+	return ip
+}
+/**
+* list all clients, for the http api.
+*/
+func ListSrsClients() (clients []*SrsClient) {
+	client_pool_lock.Lock()
+	defer client_pool_lock.Unlock()
+
+	for _, r := range client_pool {
+		clients = append(clients, r)
+	}
+	return
+}
+/**
+* find the client by id, nil if not found.
+*/
+func FindSrsClient(id SrsLogId) (*SrsClient) {
+	client_pool_lock.Lock()
+	defer client_pool_lock.Unlock()
+
+	return client_pool[id]
+}
+/**
+* the statistic of client, the type, url, age and bytes, for the http api.
+*/
+func (r *SrsClient) Stat() (v *SrsApiClient) {
+	r.stat_lock.Lock()
+	defer r.stat_lock.Unlock()
+
+	v = &SrsApiClient{Id:r.id, Ip:r.Ip(), Type:r.stat_type,
+		Vhost:r.stat_req.Vhost, App:r.stat_req.App, Stream:r.stat_req.Stream}
+	if r.stat_req.TcUrl != "" {
+		v.Url = r.stat_req.TcUrl
+		if r.stat_req.Stream != "" {
+			v.Url += "/" + r.stat_req.Stream
+		}
+	}
+	v.ChunkSize = r.stat_chunk_size
+	v.AgeMs = int64(r.kbps.Age() / time.Millisecond)
+	v.SendBytes, v.RecvBytes = r.kbps.Bytes()
+	v.SendKbps, v.RecvKbps = r.kbps.Kbps()
+	return
+}
+/**
+* get the type and stream url of client, empty before identified.
+*/
+func (r *SrsClient) Identified() (client_type string, stream_url string) {
+	r.stat_lock.Lock()
+	defer r.stat_lock.Unlock()
+
+	if r.stat_type == "" {
+		return
+	}
+	return r.stat_type, r.stat_req.StreamUrl()
+}
+/**
+* update the type, request and vhost of client for http api and log.
+*/
+func (r *SrsClient) update_stat(client_type string) {
+	r.stat_lock.Lock()
+	defer r.stat_lock.Unlock()
+
+	r.stat_type = client_type
+	r.stat_req = *r.req
+	r.stat_vhost = r.vhost
+}
 
 func (r *SrsClient) do_cycle() (err error) {
+	client_pool_lock.Lock()
+	client_pool[r.id] = r
+	client_pool_lock.Unlock()
+
+	defer func() {
+		client_pool_lock.Lock()
+		defer client_pool_lock.Unlock()
+		delete(client_pool, r.id)
+	}()
+
 	defer func(r *SrsClient) {
 		// destroy the protocol stack.
 		r.rtmp.Destroy()
@@ -91,61 +269,142 @@ func (r *SrsClient) do_cycle() (err error) {
 		if err == nil {
 			return
 		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			SrsWarn(r, r, "client timeout in phase %v, err=%v", r.phase, err)
+			return
+		}
 		SrsTrace(r, r, "client cycle completed, err=%v", err)
 	}(r)
 
 	SrsTrace(r, r, "start serve client=%v", r.conn.RemoteAddr())
 
+	// the vhost is unknown before connect app, use the timeout of default vhost.
+	handshake_timeout, connect_timeout := SRS_HANDSHAKE_TIMEOUT_MS, SRS_MIN_RECV_TIMEOUT_MS
+	if vhost := srs_config.GetVhost(SRS_CONF_DEFAULT_VHOST); vhost != nil {
+		handshake_timeout, connect_timeout = vhost.handshake_timeout_ms, vhost.connect_timeout_ms
+	}
+
+	r.enter_phase(SRS_CLIENT_PHASE_HANDSHAKE, handshake_timeout, handshake_timeout)
 	if err = r.rtmp.Handshake(); err != nil {
 		return
 	}
 
+	r.enter_phase(SRS_CLIENT_PHASE_CONNECT, connect_timeout, connect_timeout)
 	if err = r.rtmp.ConnectApp(r.req); err != nil {
 		return
 	}
 	SrsTrace(r, r, "request, tcUrl=%v(vhost=%v, app=%v), AMF%v, pageUrl=%v, swfUrl=%v",
 		r.req.TcUrl, r.req.Vhost, r.req.App, r.req.ObjectEncoding, r.req.PageUrl, r.req.SwfUrl)
 
-	// check_vhost
-	// TODO: FIXME: implements it
+	if err = r.check_vhost(); err != nil {
+		return
+	}
+	r.update_stat("")
+	r.set_timeout(r.vhost.recv_timeout_ms, r.vhost.send_timeout_ms)
+	r.enter_phase(SRS_CLIENT_PHASE_CONNECT, r.vhost.connect_timeout_ms, r.vhost.connect_timeout_ms)
+
+	if err = r.on_connect(); err != nil {
+		if e := r.rtmp.ResponseConnectReject(r.req, "connect denied by http hooks"); e != nil {
+			SrsTrace(r, r, "ignore the reject err=%v", e)
+		}
+		return
+	}
 
 	err = r.service_cycle()
 
-	// on_close
+	r.on_close()
+	return
+}
+/**
+* resolve the vhost from request, then check whether vhost is available,
+* reject the client by rtmp _error when vhost not found or disabled.
+*/
+func (r *SrsClient) check_vhost() (err error) {
+	r.query = srs_vhost_resolve(r.req)
+	if cid := r.query.Get(SRS_UPSTREAM_CID); cid != "" {
+		SrsTrace(r, r, "client from downstream server, cid=%v", cid)
+	}
+
+	if r.vhost = srs_config.GetVhost(r.req.Vhost); r.vhost == nil {
+		err = SrsError{code:ERROR_RTMP_VHOST_NOT_FOUND, desc:fmt.Sprintf("vhost %v not found", r.req.Vhost)}
+	} else if !r.vhost.enabled {
+		err = SrsError{code:ERROR_RTMP_VHOST_DISABLED, desc:fmt.Sprintf("vhost %v is disabled", r.req.Vhost)}
+	} else {
+		err = srs_check_name("app", r.req.App)
+	}
+
+	if err != nil {
+		if e := r.rtmp.ResponseConnectReject(r.req, err.(SrsError).desc); e != nil {
+			SrsTrace(r, r, "ignore the reject err=%v", e)
+		}
+		SrsWarn(r, r, "reject client, err=%v", err)
+		return
+	}
+
+	if r.req.Vhost != r.vhost.name {
+		SrsTrace(r, r, "vhost change from %v to %v", r.req.Vhost, r.vhost.name)
+		r.req.Vhost = r.vhost.name
+	}
+	return
+}
+/**
+* resolve the vhost in the query of app, for example,
+*		rtmp://ip/live?vhost=demo.srs.com/livestream
+* where the vhost is demo.srs.com, the app is live.
+* @remark, some encoder not allow ? in app, use ... instead.
+* @return the query of app, never nil.
+*/
+/**
+* check the app or stream name, which is used as the path of hls,
+* reject the .., /, \\ and NUL which may escape the dir of hls.
+*/
+func srs_check_name(kind string, name string) (err error) {
+	if strings.Contains(name, "..") || strings.ContainsAny(name, "/\\\x00") {
+		return SrsError{code:ERROR_RTMP_NAME_INVALID, desc:fmt.Sprintf("%v name %q is invalid", kind, name)}
+	}
+	return
+}
+func srs_vhost_resolve(req *rtmp.Request) (query url.Values) {
+	query = url.Values{}
+	app := strings.Replace(req.App, "...", "?", -1)
+
+	pos := strings.Index(app, "?")
+	if pos < 0 {
+		return
+	}
+	req.App = app[:pos]
+
+	var err error
+	if query, err = url.ParseQuery(app[pos + 1:]); err != nil {
+		return url.Values{}
+	}
+	if vhost := query.Get("vhost"); vhost != "" {
+		req.Vhost = vhost
+	}
 	return
 }
 func (r *SrsClient) service_cycle() (err error) {
-	ack_size := uint32(2.5 * 1000 * 1000)
+	ack_size := r.vhost.ack_size
 	if err = r.rtmp.SetWindowAckSize(ack_size); err != nil {
 		return
 	}
 	SrsTrace(r, r, "set window ack size to %v", ack_size)
 
-	bandwidth, bw_type := uint32(2.5 * 1000 * 1000), byte(2)
+	bandwidth, bw_type := r.vhost.peer_bandwidth, byte(2)
 	if err = r.rtmp.SetPeerBandwidth(bandwidth, bw_type); err != nil {
 		return
 	}
 	SrsTrace(r, r, "set bandwidth to %v, type=%v", bandwidth, bw_type)
 
 	// do bandwidth test if connect to the vhost which is for bandwidth check.
-	// TODO: FIXME: implements it
-
-	extra_data := []map[string]string {
-		{ "srs_sig": RTMP_SIG_SRS_KEY },
-		{ "srs_server": RTMP_SIG_SRS_KEY + " " + RTMP_SIG_SRS_VERSION + " (" + RTMP_SIG_SRS_URL_SHORT + ")" },
-		{ "srs_license": RTMP_SIG_SRS_LICENSE },
-		{ "srs_role": RTMP_SIG_SRS_ROLE },
-		{ "srs_url": RTMP_SIG_SRS_URL },
-		{ "srs_version": RTMP_SIG_SRS_VERSION },
-		{ "srs_site": RTMP_SIG_SRS_WEB },
-		{ "srs_email": RTMP_SIG_SRS_EMAIL },
-		{ "srs_copyright": RTMP_SIG_SRS_COPYRIGHT },
-		{ "srs_primary_authors": RTMP_SIG_SRS_PRIMARY_AUTHROS },
+	if r.vhost.bandcheck.enabled {
+		r.enter_phase(SRS_CLIENT_PHASE_BANDCHECK, r.vhost.recv_timeout_ms, r.vhost.send_timeout_ms)
+		return NewSrsBandwidth(r).BandwidthCheck()
 	}
-	if err = r.rtmp.ReponseConnectApp(r.req, "", extra_data); err != nil {
+
+	if err = r.response_connect_app(); err != nil {
 		return
 	}
-	SrsTrace(r, r, "response connect app success")
 
 	if err = r.rtmp.CallOnBWDone(); err != nil {
 		return
@@ -155,6 +414,13 @@ func (r *SrsClient) service_cycle() (err error) {
 	for {
 		err = r.stream_service_cycle()
 
+		// the kicked client is closed normally.
+		if r.Kicked() {
+			SrsTrace(r, r, "client is kicked, err=%v", err)
+			err = nil
+			return
+		}
+
 		// stream service must terminated with error, never success.
 		if err == nil {
 			SrsTrace(r, r, "stream service complete success, re-identify it")
@@ -187,25 +453,56 @@ func (r *SrsClient) service_cycle() (err error) {
 
 	return
 }
+/**
+* response the connect app with the server info.
+*/
+func (r *SrsClient) response_connect_app() (err error) {
+	extra_data := []map[string]string {
+		{ "srs_sig": RTMP_SIG_SRS_KEY },
+		{ "srs_server": RTMP_SIG_SRS_KEY + " " + RTMP_SIG_SRS_VERSION + " (" + RTMP_SIG_SRS_URL_SHORT + ")" },
+		{ "srs_license": RTMP_SIG_SRS_LICENSE },
+		{ "srs_role": RTMP_SIG_SRS_ROLE },
+		{ "srs_url": RTMP_SIG_SRS_URL },
+		{ "srs_version": RTMP_SIG_SRS_VERSION },
+		{ "srs_site": RTMP_SIG_SRS_WEB },
+		{ "srs_email": RTMP_SIG_SRS_EMAIL },
+		{ "srs_copyright": RTMP_SIG_SRS_COPYRIGHT },
+		{ "srs_primary_authors": RTMP_SIG_SRS_PRIMARY_AUTHROS },
+	}
+	if err = r.rtmp.ReponseConnectApp(r.req, "", extra_data); err != nil {
+		return
+	}
+	SrsTrace(r, r, "response connect app success")
+	return
+}
 func (r *SrsClient) stream_service_cycle() (err error) {
+	// wait for the client to play or publish.
+	r.enter_phase(SRS_CLIENT_PHASE_IDENTIFY, r.vhost.connect_timeout_ms, r.vhost.connect_timeout_ms)
+
 	var client_type string
 	if client_type, r.req.Stream, err = r.rtmp.IdentifyClient(r.res.stream_id); err != nil {
 		return
 	}
 	SrsTrace(r, r, "identify client success, type=%v, stream=%v", client_type, r.req.Stream)
+	r.update_stat(client_type)
+
+	if err = srs_check_name("stream", r.req.Stream); err != nil {
+		r.reject(client_type, "invalid stream name")
+		return
+	}
 
 	// set chunk size to larger.
-	// TODO: FIXME: implements it.
+	if err = r.set_chunk_size(); err != nil {
+		return
+	}
 
 	// find a source to serve.
 	source := FindSrsSource(r.req)
+	defer source.Release()
 	SrsTrace(r, r, "discovery source by url %v", r.req.StreamUrl())
 
-	// check publish available.
-	// TODO: FIXME: implements it.
-
 	// enable gop cache if requires
-	// TODO: FIXME: implements it.
+	source.SetCache(r.vhost.gop_cache)
 
 	// when play, start pprof when vhost is pprof, and stop when client disconnect
 	if client_type == rtmp.CLIENT_TYPE_Play && r.req.Vhost == SRS_PPROF_VHOST {
@@ -219,13 +516,14 @@ func (r *SrsClient) stream_service_cycle() (err error) {
 		}
 		SrsTrace(r, r, "start play stream")
 
-		// on_play
-		// TODO: FIXME: implements it.
+		if err = r.on_play(); err != nil {
+			r.reject(client_type, "play denied by http hooks")
+			return
+		}
 
 		err = r.playing(source)
 
-		// on_stop
-		// TODO: FIXME: implements it.
+		r.on_stop()
 
 		return err
 	case rtmp.CLIENT_TYPE_FMLEPublish:
@@ -234,13 +532,14 @@ func (r *SrsClient) stream_service_cycle() (err error) {
 		}
 		SrsTrace(r, r, "start FMLE publish stream")
 
-		// on_publish
-		// TODO: FIXME: implements it.
+		if err = r.prepare_publish(client_type, source); err != nil {
+			return
+		}
+		defer source.ReleasePublish(r)
 
 		err = r.fmle_publishing(source)
 
-		// on_unpublish
-		// TODO: FIXME: implements it.
+		r.on_unpublish()
 		return err
 	case rtmp.CLIENT_TYPE_FlashPublish:
 		if err = r.rtmp.StartFlashPublish(r.res.stream_id); err != nil {
@@ -248,13 +547,14 @@ func (r *SrsClient) stream_service_cycle() (err error) {
 		}
 		SrsTrace(r, r, "start flash publish stream")
 
-		// on_publish
-		// TODO: FIXME: implements it.
+		if err = r.prepare_publish(client_type, source); err != nil {
+			return
+		}
+		defer source.ReleasePublish(r)
 
 		err = r.flash_publishing(source)
 
-		// on_unpublish
-		// TODO: FIXME: implements it.
+		r.on_unpublish()
 
 		return err
 	}
@@ -262,6 +562,148 @@ func (r *SrsClient) stream_service_cycle() (err error) {
 	return
 }
 
+/**
+* set the chunk size to send to client, only once for each connection.
+*/
+func (r *SrsClient) set_chunk_size() (err error) {
+	if r.chunk_size == r.vhost.chunk_size {
+		return
+	}
+
+	if err = r.rtmp.SetChunkSize(uint32(r.vhost.chunk_size)); err != nil {
+		return
+	}
+	r.chunk_size = r.vhost.chunk_size
+	SrsTrace(r, r, "set chunk size to %v", r.chunk_size)
+
+	r.stat_lock.Lock()
+	defer r.stat_lock.Unlock()
+	r.stat_chunk_size = r.chunk_size
+	return
+}
+/**
+* authorize the publish client by refer and http hooks, then acquire the publish,
+* never kick the publisher for a client which should be rejected.
+*/
+func (r *SrsClient) prepare_publish(client_type string, source *SrsSource) (err error) {
+	if err = r.check_refer(client_type, r.vhost.refer_publish); err != nil {
+		return
+	}
+
+	if err = r.on_publish(); err != nil {
+		r.reject(client_type, "publish denied by http hooks")
+		return
+	}
+
+	// the edge proxy publish to origin which checks it.
+	if r.vhost.IsEdge() {
+		return
+	}
+	return r.acquire_publish(source)
+}
+/**
+* acquire the publish of source, reject the client when stream is busy,
+* or kick the old publisher when vhost is publisher_takeover.
+*/
+func (r *SrsClient) acquire_publish(source *SrsSource) (err error) {
+	if source.AcquirePublish(r, r.vhost.publisher_takeover) {
+		return
+	}
+
+	desc := fmt.Sprintf("stream %v is already publishing", r.req.StreamUrl())
+	if e := r.send_status(SRS_STATUS_LEVEL_ERROR, SRS_STATUS_CODE_PUBLISH_BAD_NAME, desc); e != nil {
+		SrsTrace(r, r, "ignore the status err=%v", e)
+	}
+	SrsWarn(r, r, "%v, sleep %vms and close", desc, r.vhost.stream_busy_sleep_ms)
+
+	time.Sleep(time.Duration(r.vhost.stream_busy_sleep_ms) * time.Millisecond)
+	return SrsError{code:ERROR_SYSTEM_STREAM_BUSY, desc:desc}
+}
+/**
+* check the pageUrl by the refer of vhost, and the refer_play or refer_publish,
+* reject the client when check failed.
+*/
+func (r *SrsClient) check_refer(client_type string, refers []string) (err error) {
+	if err = srs_refer_check(r.req.PageUrl, r.vhost.refer); err == nil {
+		err = srs_refer_check(r.req.PageUrl, refers)
+	}
+
+	if err != nil {
+		r.reject(client_type, "refer check failed")
+		SrsWarn(r, r, "check refer failed, err=%v", err)
+		return
+	}
+	SrsVerbose(r, r, "check refer success, pageUrl=%v", r.req.PageUrl)
+	return
+}
+/**
+* reject the play or publish client by onStatus error.
+*/
+func (r *SrsClient) reject(client_type string, desc string) {
+	code := SRS_STATUS_CODE_PLAY_FAILED
+	if client_type != rtmp.CLIENT_TYPE_Play {
+		code = SRS_STATUS_CODE_PUBLISH_DENIED
+	}
+
+	if err := r.send_status(SRS_STATUS_LEVEL_ERROR, code, desc); err != nil {
+		SrsTrace(r, r, "ignore the status err=%v", err)
+	}
+	SrsWarn(r, r, "reject client, code=%v, desc=%v", code, desc)
+}
+/**
+* send the onStatus message to client.
+*/
+func (r *SrsClient) send_status(level string, code string, desc string) (err error) {
+	pkt := rtmp.NewOnStatusCallPacket()
+	pkt.Data.Set("level", level)
+	pkt.Data.Set("code", code)
+	pkt.Data.Set("description", desc)
+	return r.rtmp.Protocol().SendPacket(pkt, r.res.stream_id)
+}
+
+/**
+* the http hooks, the connect, publish and play is denied when hooks failed,
+* the close, unpublish and stop is notified in background.
+*/
+func (r *SrsClient) on_connect() (err error) {
+	return r.notify_http_hooks(SRS_HTTP_HOOKS_ON_CONNECT, r.vhost.http_hooks.on_connect, false)
+}
+func (r *SrsClient) on_close() {
+	r.notify_http_hooks(SRS_HTTP_HOOKS_ON_CLOSE, r.vhost.http_hooks.on_close, true)
+}
+func (r *SrsClient) on_publish() (err error) {
+	return r.notify_http_hooks(SRS_HTTP_HOOKS_ON_PUBLISH, r.vhost.http_hooks.on_publish, false)
+}
+func (r *SrsClient) on_unpublish() {
+	r.notify_http_hooks(SRS_HTTP_HOOKS_ON_UNPUBLISH, r.vhost.http_hooks.on_unpublish, true)
+}
+func (r *SrsClient) on_play() (err error) {
+	return r.notify_http_hooks(SRS_HTTP_HOOKS_ON_PLAY, r.vhost.http_hooks.on_play, false)
+}
+func (r *SrsClient) on_stop() {
+	r.notify_http_hooks(SRS_HTTP_HOOKS_ON_STOP, r.vhost.http_hooks.on_stop, true)
+}
+/**
+* notify the http hooks, never block when background.
+*/
+func (r *SrsClient) notify_http_hooks(action string, urls []string, background bool) (err error) {
+	if !r.vhost.http_hooks.enabled || len(urls) == 0 {
+		return
+	}
+
+	req := &SrsHttpHooksRequest{
+		Action: action, ClientId: r.id, Ip: r.Ip(),
+		Vhost: r.req.Vhost, App: r.req.App, Stream: r.req.Stream,
+		TcUrl: r.req.TcUrl, PageUrl: r.req.PageUrl,
+	}
+
+	if background {
+		go srs_http_hooks_notify(r, r, urls, r.vhost.http_hooks.timeout_ms, req)
+		return
+	}
+	return srs_http_hooks_notify(r, r, urls, r.vhost.http_hooks.timeout_ms, req)
+}
+
 func (r *SrsClient) do_pprof() (err error) {
 	var f *os.File
 	if f, err = os.Create("srs.prof"); err != nil {
@@ -312,31 +754,55 @@ func (r *SrsClient) playing(source *SrsSource) (err error) {
 	} ()
 
 	// refer check
-	// TODO: FIXME: implements it.
+	if err = r.check_refer(rtmp.CLIENT_TYPE_Play, r.vhost.refer_play); err != nil {
+		return
+	}
+
+	if r.consumer, err = source.CreateConsumer(); err != nil {
+		return
+	}
 
-	r.consumer = source.CreateConsumer()
+	// the player may never send data, only the send timeout for play.
+	r.enter_phase(SRS_CLIENT_PHASE_PLAY, 0, r.vhost.send_timeout_ms)
 
-	// SrsPithyPrint
-	// TODO: FIXME: implements it.
+	pithy_print := NewSrsPithyPrint(SRS_STAGE_PLAY)
+	defer pithy_print.Close()
 
 	msg_input_channel := r.rtmp.Protocol().MessageInputChannel()
-	msg_send_channel := r.consumer.Messages()
 
 	for {
+		// when paused, stop draining the consumer.
+		msg_send_channel := r.consumer.Wait()
+		if r.paused {
+			msg_send_channel = nil
+		}
+
 		select {
 		case msg, ok := <- msg_input_channel:
 			if !ok {
 				return
 			}
+			if msg != nil {
+				pithy_print.OnRecv(len(msg.Payload))
+				r.kbps.OnRecv(len(msg.Payload))
+			}
 			if err = r.process_play_control_msg(msg); err != nil {
 				return
 			}
-		case msg, ok := <- msg_send_channel:
-			if !ok {
-				return
+		case <- msg_send_channel:
+			msgs := r.consumer.Fetch()
+			for _, msg := range msgs {
+				r.set_deadline(-1, r.send_timeout)
+				if err = r.rtmp.Protocol().SendMessage(msg, r.res.stream_id); err != nil {
+					return
+				}
+				pithy_print.OnSend(len(msg.Payload))
+				r.kbps.OnSend(len(msg.Payload))
 			}
-			if err = r.rtmp.Protocol().SendMessage(msg, r.res.stream_id); err != nil {
-				return
+
+			if len(msgs) > 0 && pithy_print.CanPrint() {
+				SrsTrace(r, r, "-> play %v, queue=%v, time=%v",
+					pithy_print, r.consumer.Len(), msgs[len(msgs) - 1].Header.Timestamp)
 			}
 		}
 	}
@@ -362,24 +828,102 @@ func (r *SrsClient) process_play_control_msg(msg *rtmp.Message) (err error) {
 	}
 
 	// pause
-	// TODO: FIXME: implements it
+	if pause, ok := pkt.(*rtmp.PausePacket); ok {
+		return r.on_play_pause(pause.IsPause)
+	}
+	return
+}
+/**
+* when client pause, stop sending to client and use the paused timeout,
+* when unpause, resume from the last keyframe.
+*/
+func (r *SrsClient) on_play_pause(is_pause bool) (err error) {
+	if r.paused == is_pause {
+		return
+	}
+	r.paused = is_pause
+	r.consumer.Pause(is_pause)
+
+	code, desc := SRS_STATUS_CODE_UNPAUSE_NOTIFY, "Unpaused stream."
+	if is_pause {
+		code, desc = SRS_STATUS_CODE_PAUSE_NOTIFY, "Paused stream."
+		r.set_timeout(r.vhost.paused_recv_timeout_ms, r.vhost.paused_send_timeout_ms)
+		// the paused client never send data, wait for the unpause.
+		r.set_deadline(r.recv_timeout, r.send_timeout)
+	} else {
+		r.set_timeout(r.vhost.recv_timeout_ms, r.vhost.send_timeout_ms)
+		r.set_deadline(0, r.send_timeout)
+	}
+
+	if err = r.send_status(SRS_STATUS_LEVEL_STATUS, code, desc); err != nil {
+		return
+	}
+	SrsTrace(r, r, "process pause=%v, recv_timeout=%v, send_timeout=%v", is_pause, r.recv_timeout, r.send_timeout)
 	return
 }
+func (r *SrsClient) set_timeout(recv_timeout_ms int, send_timeout_ms int) {
+	r.recv_timeout = time.Duration(recv_timeout_ms) * time.Millisecond
+	r.send_timeout = time.Duration(send_timeout_ms) * time.Millisecond
+}
+/**
+* enter the phase, the io of phase must complete in the timeout, 0 for no timeout.
+*/
+func (r *SrsClient) enter_phase(phase string, recv_timeout_ms int, send_timeout_ms int) {
+	r.phase = phase
+	r.set_deadline(time.Duration(recv_timeout_ms) * time.Millisecond, time.Duration(send_timeout_ms) * time.Millisecond)
+}
+/**
+* set the deadline of recv and send, 0 for no deadline, negative to keep the current,
+* never override the deadline of kick.
+*/
+func (r *SrsClient) set_deadline(recv_timeout time.Duration, send_timeout time.Duration) {
+	r.deadline_lock.Lock()
+	defer r.deadline_lock.Unlock()
+
+	if r.Kicked() {
+		return
+	}
+
+	now := time.Now()
+	if recv_timeout == 0 {
+		r.conn.SetReadDeadline(time.Time{})
+	} else if recv_timeout > 0 {
+		r.conn.SetReadDeadline(now.Add(recv_timeout))
+	}
+
+	if send_timeout == 0 {
+		r.conn.SetWriteDeadline(time.Time{})
+	} else if send_timeout > 0 {
+		r.conn.SetWriteDeadline(now.Add(send_timeout))
+	}
+}
 
 func (r *SrsClient) fmle_publishing(source *SrsSource) (err error) {
-	// refer check
-	// TODO: FIXME: implements it.
+	// notify the hls to prepare, or proxy to origin for edge.
+	if err = r.publish_start(rtmp.CLIENT_TYPE_FMLEPublish, source); err != nil {
+		return
+	}
+	defer r.publish_stop()
 
-	// notify the hls to prepare when publish start.
-	// TODO: FIXME: implements it.
+	pithy_print := NewSrsPithyPrint(SRS_STAGE_PUBLISH)
+	defer pithy_print.Close()
 
+	r.enter_phase(SRS_CLIENT_PHASE_PUBLISH, r.vhost.recv_timeout_ms, r.vhost.send_timeout_ms)
 	for {
-		// read from client.
+		// read from client, the publisher must send data in the recv timeout.
+		r.set_deadline(r.recv_timeout, r.send_timeout)
+
 		var msg *rtmp.Message
 		if msg, err = r.rtmp.Protocol().RecvMessage(); err != nil {
 			return
 		}
 
+		pithy_print.OnRecv(len(msg.Payload))
+		r.kbps.OnRecv(len(msg.Payload))
+		if pithy_print.CanPrint() {
+			SrsTrace(r, r, "<- publish %v, time=%v", pithy_print, msg.Header.Timestamp)
+		}
+
 		// process UnPublish event.
 		if msg.Header.IsAmf0Command() || msg.Header.IsAmf3Command() {
 			var pkt interface {}
@@ -401,19 +945,31 @@ func (r *SrsClient) fmle_publishing(source *SrsSource) (err error) {
 	return
 }
 func (r *SrsClient) flash_publishing(source *SrsSource) (err error) {
-	// refer check
-	// TODO: FIXME: implements it.
+	// notify the hls to prepare, or proxy to origin for edge.
+	if err = r.publish_start(rtmp.CLIENT_TYPE_FlashPublish, source); err != nil {
+		return
+	}
+	defer r.publish_stop()
 
-	// notify the hls to prepare when publish start.
-	// TODO: FIXME: implements it.
+	pithy_print := NewSrsPithyPrint(SRS_STAGE_PUBLISH)
+	defer pithy_print.Close()
 
+	r.enter_phase(SRS_CLIENT_PHASE_PUBLISH, r.vhost.recv_timeout_ms, r.vhost.send_timeout_ms)
 	for {
-		// read from client.
+		// read from client, the publisher must send data in the recv timeout.
+		r.set_deadline(r.recv_timeout, r.send_timeout)
+
 		var msg *rtmp.Message
 		if msg, err = r.rtmp.Protocol().RecvMessage(); err != nil {
 			return
 		}
 
+		pithy_print.OnRecv(len(msg.Payload))
+		r.kbps.OnRecv(len(msg.Payload))
+		if pithy_print.CanPrint() {
+			SrsTrace(r, r, "<- publish %v, time=%v", pithy_print, msg.Header.Timestamp)
+		}
+
 		// process UnPublish event.
 		if msg.Header.IsAmf0Command() || msg.Header.IsAmf3Command() {
 			SrsTrace(r, r, "flash publish finished.")
@@ -426,22 +982,52 @@ func (r *SrsClient) flash_publishing(source *SrsSource) (err error) {
 	}
 	return
 }
-func (r *SrsClient) process_publish_message(source *SrsSource, msg *rtmp.Message) (err error) {
-	// process audio packet
-	if msg.Header.IsAudio() {
-		if err = source.OnAudio(msg); err != nil {
-			return
-		}
+/**
+* when publish start, notify the source to prepare,
+* while the edge proxy the publish to origin.
+*/
+func (r *SrsClient) publish_start(client_type string, source *SrsSource) (err error) {
+	if !r.vhost.IsEdge() {
+		source.OnPublish()
+		return
 	}
 
-	// process video packet
-	if msg.Header.IsVideo() {
-		if err = source.OnVideo(msg); err != nil {
-			return
+	r.proxy = NewSrsEdgeProxy(r)
+	if err = r.proxy.Connect(); err != nil {
+		if !r.relay_status() {
+			r.reject(client_type, "proxy publish to origin failed")
 		}
+		return
 	}
-
-	// process onMetaData
-	// TODO: FIXME: implements it.
 	return
 }
+func (r *SrsClient) publish_stop() {
+	if r.proxy != nil {
+		r.relay_status()
+		r.proxy.Close()
+		r.proxy = nil
+	}
+}
+/**
+* relay the error status of origin to encoder, return whether relayed.
+*/
+func (r *SrsClient) relay_status() (bool) {
+	status := r.proxy.Status()
+	if status == nil {
+		return false
+	}
+
+	if err := r.send_status(status.level, status.code, status.desc); err != nil {
+		SrsTrace(r, r, "ignore the status err=%v", err)
+	}
+	return true
+}
+func (r *SrsClient) process_publish_message(source *SrsSource, msg *rtmp.Message) (err error) {
+	// the edge proxy the message to origin.
+	if r.proxy != nil {
+		return r.proxy.Proxy(msg)
+	}
+
+	// process audio, video and onMetaData, when still the publisher of source.
+	return source.OnPublisherMessage(r, msg)
+}
@@ -0,0 +1,138 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+/**
+* create the client on the server side of a tcp connection,
+* the peer is the remote side which never send or recv.
+*/
+func srs_test_client(t *testing.T, recv_timeout_ms int, send_timeout_ms int) (r *SrsClient, peer *net.TCPConn) {
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP:net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed, err=%v", err)
+	}
+	defer listener.Close()
+
+	if peer, err = net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr)); err != nil {
+		t.Fatalf("dial failed, err=%v", err)
+	}
+
+	var conn *net.TCPConn
+	if conn, err = listener.AcceptTCP(); err != nil {
+		t.Fatalf("accept failed, err=%v", err)
+	}
+
+	if r, err = NewSrsClient(conn); err != nil {
+		t.Fatalf("create client failed, err=%v", err)
+	}
+	r.req.Vhost, r.req.App, r.req.Stream = SRS_CONF_DEFAULT_VHOST, "live", t.Name()
+	r.vhost = NewSrsConfVhost(SRS_CONF_DEFAULT_VHOST)
+	r.vhost.recv_timeout_ms, r.vhost.send_timeout_ms = recv_timeout_ms, send_timeout_ms
+	r.set_timeout(recv_timeout_ms, send_timeout_ms)
+	return
+}
+
+func TestSilentPublisherTimeout(t *testing.T) {
+	r, peer := srs_test_client(t, 200, 200)
+	defer peer.Close()
+	defer r.rtmp.Destroy()
+
+	source := FindSrsSource(r.req)
+	defer source.Release()
+
+	// the publisher never send any data.
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.fmle_publishing(source)
+	}()
+
+	select {
+	case err := <- done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Fatalf("silent publisher should be disconnected by timeout, err=%v", err)
+		}
+		if r.phase != SRS_CLIENT_PHASE_PUBLISH {
+			t.Errorf("timeout in phase %v, expect %v", r.phase, SRS_CLIENT_PHASE_PUBLISH)
+		}
+		t.Logf("silent publisher disconnected after %v, err=%v", time.Now().Sub(start), err)
+	case <- time.After(5 * time.Second):
+		t.Fatalf("silent publisher not disconnected")
+	}
+}
+
+func TestStalledPlayerTimeout(t *testing.T) {
+	r, peer := srs_test_client(t, 200, 200)
+	defer peer.Close()
+	defer r.rtmp.Destroy()
+
+	// use small buffers, the peer never read, so the send is blocked soon.
+	peer.SetReadBuffer(4096)
+	r.conn.SetWriteBuffer(4096)
+
+	source := FindSrsSource(r.req)
+	defer source.Release()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- r.playing(source)
+	}()
+
+	// feed the source with video until the player disconnected.
+	payload := make([]byte, 64 * 1024)
+	payload[0], payload[1] = 0x17, 0x01
+	for timestamp := uint64(0); ; timestamp += 40 {
+		select {
+		case err := <- done:
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				t.Fatalf("stalled player should be disconnected by timeout, err=%v", err)
+			}
+			if r.phase != SRS_CLIENT_PHASE_PLAY {
+				t.Errorf("timeout in phase %v, expect %v", r.phase, SRS_CLIENT_PHASE_PLAY)
+			}
+			t.Logf("stalled player disconnected after %v, err=%v", time.Now().Sub(start), err)
+			return
+		case <- time.After(time.Millisecond):
+		}
+
+		if time.Now().Sub(start) > 5 * time.Second {
+			t.Fatalf("stalled player not disconnected")
+		}
+
+		// the video message type is 9.
+		msg := &rtmp.Message{
+			Header: &rtmp.MessageHeader{ MessageType: 9, PayloadLength: uint32(len(payload)), Timestamp: timestamp },
+			Payload: payload,
+		}
+		if err := source.OnVideo(msg); err != nil {
+			t.Fatalf("feed video failed, err=%v", err)
+		}
+	}
+}
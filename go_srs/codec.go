@@ -0,0 +1,320 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+)
+
+// the codec of FLV video tag, @see: E.4.3.1 VIDEODATA of flv spec.
+// the frame type, the high 4bits of first byte.
+const SRS_CODEC_VIDEO_FRAME_KEYFRAME = 1
+// the codec id, the low 4bits of first byte.
+const SRS_CODEC_VIDEO_AVC = 7
+// the AVCPacketType, the second byte when codec is AVC.
+const SRS_CODEC_VIDEO_AVC_SEQUENCE_HEADER = 0
+const SRS_CODEC_VIDEO_AVC_NALU = 1
+
+// the codec of FLV audio tag, @see: E.4.2.1 AUDIODATA of flv spec.
+// the sound format, the high 4bits of first byte.
+const SRS_CODEC_AUDIO_AAC = 10
+// the AACPacketType, the second byte when sound format is AAC.
+const SRS_CODEC_AUDIO_AAC_SEQUENCE_HEADER = 0
+const SRS_CODEC_AUDIO_AAC_RAW = 1
+
+/**
+* whether the payload of video message is a keyframe.
+*/
+func srs_codec_video_is_keyframe(data []byte) (bool) {
+	if len(data) < 1 {
+		return false
+	}
+	return (data[0] >> 4) & 0x0f == SRS_CODEC_VIDEO_FRAME_KEYFRAME
+}
+/**
+* whether the payload of video message is h.264.
+*/
+func srs_codec_video_is_h264(data []byte) (bool) {
+	if len(data) < 1 {
+		return false
+	}
+	return data[0] & 0x0f == SRS_CODEC_VIDEO_AVC
+}
+/**
+* whether the payload of video message is the AVC sequence header(sps/pps).
+*/
+func srs_codec_video_is_sequence_header(data []byte) (bool) {
+	if !srs_codec_video_is_h264(data) || !srs_codec_video_is_keyframe(data) || len(data) < 2 {
+		return false
+	}
+	return data[1] == SRS_CODEC_VIDEO_AVC_SEQUENCE_HEADER
+}
+/**
+* whether the payload of audio message is aac.
+*/
+func srs_codec_audio_is_aac(data []byte) (bool) {
+	if len(data) < 1 {
+		return false
+	}
+	return (data[0] >> 4) & 0x0f == SRS_CODEC_AUDIO_AAC
+}
+/**
+* whether the payload of audio message is the AAC sequence header(AudioSpecificConfig).
+*/
+func srs_codec_audio_is_sequence_header(data []byte) (bool) {
+	if !srs_codec_audio_is_aac(data) || len(data) < 2 {
+		return false
+	}
+	return data[1] == SRS_CODEC_AUDIO_AAC_SEQUENCE_HEADER
+}
+
+/**
+* decode the payload of amf0 data message, for example,
+*		@setDataFrame, onMetaData, {...}
+*		onMetaData, {...}
+* @return the payload of onMetaData without @setDataFrame, and the metadata,
+*		nil payload if not onMetaData.
+*/
+func srs_codec_decode_metadata(data []byte) (payload []byte, metadata map[string]interface{}, err error) {
+	dec := NewSrsAmf0Decoder(data)
+
+	var name string
+	if name, err = dec.ReadString(); err != nil {
+		return nil, nil, SrsError{code:ERROR_CODEC_METADATA_INVALID, desc:fmt.Sprintf("decode data name failed, err=%v", err)}
+	}
+
+	// the FMLE send @setDataFrame, the player requires onMetaData.
+	payload = data
+	if name == "@setDataFrame" {
+		payload = data[dec.Pos():]
+		if name, err = dec.ReadString(); err != nil {
+			return nil, nil, SrsError{code:ERROR_CODEC_METADATA_INVALID, desc:fmt.Sprintf("decode metadata name failed, err=%v", err)}
+		}
+	}
+
+	if name != "onMetaData" {
+		return nil, nil, nil
+	}
+
+	var v interface{}
+	if v, err = dec.ReadAny(); err != nil {
+		return nil, nil, SrsError{code:ERROR_CODEC_METADATA_INVALID, desc:fmt.Sprintf("decode metadata failed, err=%v", err)}
+	}
+
+	// the metadata is object or ecma array, ignore the others.
+	if metadata, _ = v.(map[string]interface{}); metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return
+}
+
+// the sample rate of aac, @see: 1.6.3.4 samplingFrequencyIndex of aac spec.
+var srs_aac_sample_rates []int = []int{
+	96000, 88200, 64000, 48000, 44100, 32000,
+	24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// the nalu type of h.264, @see: 7.4.1 Table 7-1 NAL unit type codes of h.264 spec.
+const SRS_AVC_NALU_IDR = 5
+const SRS_AVC_NALU_SPS = 7
+const SRS_AVC_NALU_PPS = 8
+const SRS_AVC_NALU_AUD = 9
+
+/**
+* the codec of stream, parsed from the sequence headers,
+* to demux the h.264 nalus and aac raw data from FLV tags.
+*/
+type SrsCodec struct {
+	// the h.264 profile and level, from the AVCDecoderConfigurationRecord.
+	avc_profile byte
+	avc_level byte
+	// the size of NALU length, generally 4.
+	nalu_length_size int
+	// the sps and pps.
+	sps []byte
+	pps []byte
+	// the aac object type, sample rate index and channels, from AudioSpecificConfig.
+	aac_object byte
+	aac_sample_rate_index byte
+	aac_channels byte
+}
+func NewSrsCodec() (*SrsCodec) {
+	return &SrsCodec{}
+}
+func (r *SrsCodec) HasVideo() (bool) {
+	return r.nalu_length_size > 0
+}
+func (r *SrsCodec) HasAudio() (bool) {
+	return r.aac_object > 0
+}
+func (r *SrsCodec) AacSampleRate() (int) {
+	if int(r.aac_sample_rate_index) < len(srs_aac_sample_rates) {
+		return srs_aac_sample_rates[r.aac_sample_rate_index]
+	}
+	return 0
+}
+/**
+* decode the AVCDecoderConfigurationRecord in the video sequence header,
+* @see: 5.2.4.1.1 Syntax of ISO_IEC_14496-15-AVC-format-2012.pdf
+*/
+func (r *SrsCodec) DecodeVideoSequenceHeader(data []byte) (err error) {
+	// skip the flv video tag header, 5bytes.
+	if len(data) < 5 + 6 {
+		return SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc sequence header too short"}
+	}
+	data = data[5:]
+
+	r.avc_profile, r.avc_level = data[1], data[3]
+	r.nalu_length_size = int(data[4] & 0x03) + 1
+	if r.nalu_length_size == 3 {
+		return SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc nalu length size 3 not supported"}
+	}
+
+	var sps, pps [][]byte
+	pos := 5
+	if sps, pos, err = srs_codec_avc_parameter_sets(data, pos, 0x1f); err != nil {
+		return
+	}
+	if pps, pos, err = srs_codec_avc_parameter_sets(data, pos, 0xff); err != nil {
+		return
+	}
+	if len(sps) == 0 || len(pps) == 0 {
+		return SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc sequence header without sps or pps"}
+	}
+
+	r.sps, r.pps = sps[0], pps[0]
+	return
+}
+func srs_codec_avc_parameter_sets(data []byte, pos int, mask byte) (sets [][]byte, next int, err error) {
+	if pos >= len(data) {
+		return nil, pos, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc parameter sets not enough"}
+	}
+	count := int(data[pos] & mask)
+	pos++
+
+	for i := 0; i < count; i++ {
+		if pos + 2 > len(data) {
+			return nil, pos, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc parameter set length not enough"}
+		}
+		size := int(data[pos]) << 8 | int(data[pos + 1])
+		pos += 2
+		if pos + size > len(data) {
+			return nil, pos, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc parameter set not enough"}
+		}
+		sets = append(sets, data[pos:pos + size])
+		pos += size
+	}
+	return sets, pos, nil
+}
+/**
+* demux the h.264 nalus from the video tag,
+* @return the composition time in ms and the nalus.
+*/
+func (r *SrsCodec) DemuxVideo(data []byte) (cts int32, nalus [][]byte, err error) {
+	if !r.HasVideo() {
+		return 0, nil, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc sequence header not found"}
+	}
+	if len(data) < 5 {
+		return 0, nil, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc video tag too short"}
+	}
+
+	// the composition time is SI24.
+	cts = int32(uint32(data[2]) << 16 | uint32(data[3]) << 8 | uint32(data[4]))
+	if cts & 0x800000 != 0 {
+		cts -= 0x1000000
+	}
+
+	for pos := 5; pos < len(data); {
+		if pos + r.nalu_length_size > len(data) {
+			return 0, nil, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc nalu length not enough"}
+		}
+		size := 0
+		for i := 0; i < r.nalu_length_size; i++ {
+			size = size << 8 | int(data[pos + i])
+		}
+		pos += r.nalu_length_size
+
+		if size < 0 || pos + size > len(data) {
+			return 0, nil, SrsError{code:ERROR_CODEC_AVC_INVALID, desc:"avc nalu not enough"}
+		}
+		nalus = append(nalus, data[pos:pos + size])
+		pos += size
+	}
+	return
+}
+/**
+* decode the AudioSpecificConfig in the audio sequence header,
+* @see: 1.6.2.1 AudioSpecificConfig of aac spec.
+*/
+func (r *SrsCodec) DecodeAudioSequenceHeader(data []byte) (err error) {
+	// skip the flv audio tag header, 2bytes.
+	if len(data) < 2 + 2 {
+		return SrsError{code:ERROR_CODEC_AAC_INVALID, desc:"aac sequence header too short"}
+	}
+	data = data[2:]
+
+	r.aac_object = (data[0] >> 3) & 0x1f
+	r.aac_sample_rate_index = ((data[0] << 1) & 0x0e) | ((data[1] >> 7) & 0x01)
+	r.aac_channels = (data[1] >> 3) & 0x0f
+
+	if r.aac_object == 0 || int(r.aac_sample_rate_index) >= len(srs_aac_sample_rates) {
+		r.aac_object = 0
+		return SrsError{code:ERROR_CODEC_AAC_INVALID, desc:"aac object or sample rate invalid"}
+	}
+	return
+}
+/**
+* demux the aac raw data from the audio tag, and mux to ADTS frame.
+* @see: 1.A.2.2 Audio_Data_Transport_Stream frame, ADTS of aac spec.
+*/
+func (r *SrsCodec) DemuxAudioAdts(data []byte) (frame []byte, err error) {
+	if !r.HasAudio() {
+		return nil, SrsError{code:ERROR_CODEC_AAC_INVALID, desc:"aac sequence header not found"}
+	}
+	if len(data) < 2 {
+		return nil, SrsError{code:ERROR_CODEC_AAC_INVALID, desc:"aac audio tag too short"}
+	}
+	raw := data[2:]
+
+	// the frame length includes the 7bytes header.
+	size := len(raw) + 7
+	if size > 0x1fff {
+		return nil, SrsError{code:ERROR_CODEC_AAC_INVALID, desc:"aac frame too large"}
+	}
+
+	// the profile is object type minus 1, the main, LC or SSR.
+	profile := r.aac_object - 1
+	if profile > 3 {
+		profile = 1
+	}
+
+	frame = make([]byte, 7, size)
+	frame[0] = 0xff
+	frame[1] = 0xf1
+	frame[2] = (profile << 6) | ((r.aac_sample_rate_index & 0x0f) << 2) | ((r.aac_channels >> 2) & 0x01)
+	frame[3] = ((r.aac_channels & 0x03) << 6) | byte((size >> 11) & 0x03)
+	frame[4] = byte((size >> 3) & 0xff)
+	frame[5] = byte((size & 0x07) << 5) | 0x1f
+	frame[6] = 0xfc
+	frame = append(frame, raw...)
+	return
+}
@@ -0,0 +1,1016 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync/atomic"
+)
+
+// the vhost to use when the vhost of client not configed.
+const SRS_CONF_DEFAULT_VHOST = "__defaultVhost__"
+// the default log file, when log_tank is file.
+const SRS_CONF_DEFAULT_LOG_FILE = "./objs/srs.log"
+// the default listen port of rtmp server.
+const SRS_CONF_DEFAULT_LISTEN = "1935"
+// the default window ack size and peer bandwidth, in bytes.
+const SRS_CONF_DEFAULT_ACK_SIZE = 2500000
+const SRS_CONF_DEFAULT_PEER_BANDWIDTH = 2500000
+// the default max messages in the queue of consumer.
+const SRS_CONF_DEFAULT_QUEUE_LENGTH = 1000
+// the default max duration in ms of messages in the queue of consumer.
+const SRS_CONF_DEFAULT_QUEUE_DURATION = 30*1000
+// the default chunk size to send to client, and the range of chunk size,
+// @see: 5.4.1. Set Chunk Size (1) of rtmp spec.
+const SRS_CONF_DEFAULT_CHUNK_SIZE = 60000
+const SRS_CONF_MIN_CHUNK_SIZE = 128
+const SRS_CONF_MAX_CHUNK_SIZE = 65536
+// the default bandcheck interval in ms of each ip, duration in ms and limit kbps.
+const SRS_CONF_DEFAULT_BANDCHECK_INTERVAL = 30*1000
+const SRS_CONF_DEFAULT_BANDCHECK_DURATION = 3*1000
+const SRS_CONF_DEFAULT_BANDCHECK_LIMIT_KBPS = 4000
+// the default hls path, fragment and window in ms.
+const SRS_CONF_DEFAULT_HLS_PATH = "./objs/nginx/html"
+const SRS_CONF_DEFAULT_HLS_FRAGMENT = 10*1000
+const SRS_CONF_DEFAULT_HLS_WINDOW = 60*1000
+// the default listen port and dir of http server.
+const SRS_CONF_DEFAULT_HTTP_SERVER_LISTEN = "8080"
+const SRS_CONF_DEFAULT_HTTP_SERVER_DIR = "./objs/nginx/html"
+// the default listen port of http api.
+const SRS_CONF_DEFAULT_HTTP_API_LISTEN = "1985"
+// the default interval in ms of pithy print.
+const SRS_CONF_DEFAULT_PITHY_PRINT = 10*1000
+// the default timeout in ms of http hooks.
+const SRS_CONF_DEFAULT_HTTP_HOOKS_TIMEOUT = 3*1000
+// the default max size in bytes and duration in ms of gop cache.
+const SRS_CONF_DEFAULT_GOP_CACHE_MAX_SIZE = 16*1024*1024
+const SRS_CONF_DEFAULT_GOP_CACHE_MAX_DURATION = 30*1000
+// the mode of vhost, the origin serve the local streams,
+// while the edge play the stream from origin on demand.
+const SRS_CONF_VHOST_MODE_ORIGIN = "origin"
+const SRS_CONF_VHOST_MODE_EDGE = "edge"
+// the default time in ms to stop the ingest of edge after the last player gone.
+const SRS_CONF_DEFAULT_EDGE_IDLE_TIMEOUT = 10*1000
+// the default time in ms to cleanup the source after no client and publisher.
+const SRS_CONF_DEFAULT_SOURCE_IDLE_TIMEOUT = 60*1000
+
+/**
+* the global config, use the default values
+* when no config file specified.
+*/
+var srs_config *SrsConfig = NewSrsConfig()
+
+/**
+* the directive of config file, for example:
+*		listen 1935;
+*		vhost __defaultVhost__ {
+*			ack_size 2500000;
+*		}
+* where listen, vhost and ack_size are directives,
+* the ack_size is the sub directive of vhost.
+*/
+type SrsConfDirective struct {
+	// the line of directive in config file.
+	conf_line int
+	// the name of directive, for example, "listen".
+	name string
+	// the args of directive, for example, ["1935"].
+	args []string
+	// the sub directives in block, for example, the directives of vhost.
+	directives []*SrsConfDirective
+}
+func (r *SrsConfDirective) Arg0() (string) {
+	if len(r.args) > 0 {
+		return r.args[0]
+	}
+	return ""
+}
+/**
+* get the first sub directive by name, nil if not found.
+*/
+func (r *SrsConfDirective) Get(name string) (*SrsConfDirective) {
+	for _, v := range r.directives {
+		if v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+/**
+* the vhost section of config.
+*/
+type SrsConfVhost struct {
+	// the name of vhost, for example, __defaultVhost__
+	name string
+	// whether the vhost is enabled, reject the client when disabled.
+	enabled bool
+	// the window ack size and peer bandwidth to set for client.
+	ack_size uint32
+	peer_bandwidth uint32
+	// the max messages in the queue of each consumer.
+	queue_length int
+	// the max duration in ms of messages in the queue of each consumer.
+	queue_duration_ms int
+	// the chunk size to send to client.
+	chunk_size int
+	// the timeout to complete handshake, and to connect, play or publish.
+	// @remark the handshake and connect app use the default vhost, for vhost is unknown.
+	handshake_timeout_ms int
+	connect_timeout_ms int
+	// the recv/send timeout for client.
+	recv_timeout_ms int
+	send_timeout_ms int
+	// the recv/send timeout when client paused.
+	paused_recv_timeout_ms int
+	paused_send_timeout_ms int
+	// sleep for a while before close the busy stream.
+	stream_busy_sleep_ms int
+	// whether cache the last gop, for player to start fast.
+	gop_cache bool
+	// the max size in bytes and duration in ms of gop cache.
+	gop_cache_max_size int
+	gop_cache_max_duration_ms int
+	// whether the new publisher kicks the old one,
+	// otherwise the new publisher is rejected when stream is busy.
+	publisher_takeover bool
+	// the http callbacks of vhost.
+	http_hooks *SrsConfHttpHooks
+	// the bandcheck of vhost.
+	bandcheck *SrsConfBandcheck
+	// the hls of vhost.
+	hls *SrsConfHls
+	// the transcode of vhost.
+	transcode *SrsConfTranscode
+	// the allowed domains of pageUrl for all clients, play clients and publish clients.
+	refer []string
+	refer_play []string
+	refer_publish []string
+	// the destinations to forward the stream to, for example, 127.0.0.1:1936
+	forward []string
+	// the mode of vhost, origin or edge.
+	mode string
+	// the origin servers of edge, for example, 127.0.0.1:1935
+	origin []string
+	// the time in ms to stop the ingest of edge after the last player gone.
+	edge_idle_timeout_ms int
+	// the time in ms to cleanup the source after no client and publisher.
+	source_idle_timeout_ms int
+	// the log level of vhost, use the global log level when unknown,
+	// atomic for it's reloaded when SIGUSR1, use LogLevel to get it.
+	log_level int32
+}
+func NewSrsConfVhost(name string) (*SrsConfVhost) {
+	r := &SrsConfVhost{}
+	r.name = name
+	r.enabled = true
+	r.ack_size = SRS_CONF_DEFAULT_ACK_SIZE
+	r.peer_bandwidth = SRS_CONF_DEFAULT_PEER_BANDWIDTH
+	r.queue_length = SRS_CONF_DEFAULT_QUEUE_LENGTH
+	r.queue_duration_ms = SRS_CONF_DEFAULT_QUEUE_DURATION
+	r.chunk_size = SRS_CONF_DEFAULT_CHUNK_SIZE
+	r.handshake_timeout_ms = SRS_HANDSHAKE_TIMEOUT_MS
+	r.connect_timeout_ms = SRS_MIN_RECV_TIMEOUT_MS
+	r.recv_timeout_ms = SRS_RECV_TIMEOUT_MS
+	r.send_timeout_ms = SRS_SEND_TIMEOUT_MS
+	r.paused_recv_timeout_ms = SRS_PAUSED_RECV_TIMEOUT_MS
+	r.paused_send_timeout_ms = SRS_PAUSED_SEND_TIMEOUT_MS
+	r.stream_busy_sleep_ms = SRS_STREAM_BUSY_SLEEP_MS
+	r.gop_cache = true
+	r.gop_cache_max_size = SRS_CONF_DEFAULT_GOP_CACHE_MAX_SIZE
+	r.gop_cache_max_duration_ms = SRS_CONF_DEFAULT_GOP_CACHE_MAX_DURATION
+	r.http_hooks = NewSrsConfHttpHooks()
+	r.bandcheck = NewSrsConfBandcheck()
+	r.hls = NewSrsConfHls()
+	r.transcode = NewSrsConfTranscode()
+	r.mode = SRS_CONF_VHOST_MODE_ORIGIN
+	r.edge_idle_timeout_ms = SRS_CONF_DEFAULT_EDGE_IDLE_TIMEOUT
+	r.source_idle_timeout_ms = SRS_CONF_DEFAULT_SOURCE_IDLE_TIMEOUT
+	r.log_level = SRS_LOG_LEVEL_UNKNOWN
+	return r
+}
+/**
+* whether the vhost is edge, which play the stream from origin.
+*/
+func (r *SrsConfVhost) IsEdge() (bool) {
+	return r.mode == SRS_CONF_VHOST_MODE_EDGE
+}
+
+/**
+* the transcode section of vhost, to start the external encoders
+* when stream publish, and stop them when unpublish.
+*/
+type SrsConfTranscode struct {
+	// whether the transcode is enabled.
+	enabled bool
+	// the command line of encoders, the [vhost], [app] and [stream]
+	// in args are replaced by the stream, for example:
+	//		ffmpeg -i rtmp://127.0.0.1/[app]?vhost=[vhost]/[stream] ...
+	engines [][]string
+}
+func NewSrsConfTranscode() (*SrsConfTranscode) {
+	r := &SrsConfTranscode{}
+	return r
+}
+
+/**
+* the hls section of vhost, to deliver stream in hls.
+*/
+type SrsConfHls struct {
+	// whether the hls is enabled.
+	enabled bool
+	// the dir to write the m3u8 and ts files.
+	path string
+	// the duration in ms of each ts segment, cut on keyframe.
+	fragment_ms int
+	// the duration in ms of all segments in m3u8.
+	window_ms int
+}
+func NewSrsConfHls() (*SrsConfHls) {
+	r := &SrsConfHls{}
+	r.path = SRS_CONF_DEFAULT_HLS_PATH
+	r.fragment_ms = SRS_CONF_DEFAULT_HLS_FRAGMENT
+	r.window_ms = SRS_CONF_DEFAULT_HLS_WINDOW
+	return r
+}
+
+/**
+* the bandcheck section of vhost, the client connect to
+* the vhost is for bandwidth check, never play or publish.
+*/
+type SrsConfBandcheck struct {
+	// whether the vhost is for bandcheck.
+	enabled bool
+	// the key of client to check, in query of tcUrl, for example,
+	//		rtmp://127.0.0.1/app?key=35c9b402c12a7246868752e2878f7e0e
+	key string
+	// the min interval in ms of bandcheck for each ip.
+	interval_ms int
+	// the duration in ms to play bytes and publish bytes.
+	duration_ms int
+	// the max kbps to play bytes to client.
+	limit_kbps int
+}
+func NewSrsConfBandcheck() (*SrsConfBandcheck) {
+	r := &SrsConfBandcheck{}
+	r.interval_ms = SRS_CONF_DEFAULT_BANDCHECK_INTERVAL
+	r.duration_ms = SRS_CONF_DEFAULT_BANDCHECK_DURATION
+	r.limit_kbps = SRS_CONF_DEFAULT_BANDCHECK_LIMIT_KBPS
+	return r
+}
+
+/**
+* the http_hooks section of vhost, the urls to POST when client events.
+*/
+type SrsConfHttpHooks struct {
+	// whether the http hooks is enabled.
+	enabled bool
+	// the timeout in ms for each hook.
+	timeout_ms int
+	// the urls of each event.
+	on_connect []string
+	on_close []string
+	on_publish []string
+	on_unpublish []string
+	on_play []string
+	on_stop []string
+}
+func NewSrsConfHttpHooks() (*SrsConfHttpHooks) {
+	r := &SrsConfHttpHooks{}
+	r.timeout_ms = SRS_CONF_DEFAULT_HTTP_HOOKS_TIMEOUT
+	return r
+}
+
+/**
+* the config of srs, parsed from the config file.
+*/
+type SrsConfig struct {
+	// the config file, empty when use the default config.
+	file string
+	// the root directive, whose sub directives are the global directives.
+	root *SrsConfDirective
+	// the id of node in cluster, the prefix of client id, hostname if empty.
+	node_id string
+	// the log tank, console or file, the log level and the log file.
+	log_tank string
+	// the log format, text or json.
+	log_format string
+	log_level int
+	log_file string
+	// the listen endpoints of rtmp, for example, 1935 or 127.0.0.1:1935
+	listen []string
+	// the http server to deliver hls and static files.
+	http_server *SrsConfHttpServer
+	// the http api to query the statistic of server.
+	http_api *SrsConfHttpApi
+	// the interval of pithy print for each stage.
+	pithy_print *SrsConfPithyPrint
+	// the vhosts by name.
+	vhosts map[string]*SrsConfVhost
+}
+func NewSrsConfig() (*SrsConfig) {
+	r := &SrsConfig{}
+	r.root = &SrsConfDirective{}
+	r.log_tank = SRS_LOG_TANK_CONSOLE
+	r.log_format = SRS_LOG_FORMAT_TEXT
+	r.log_level = SRS_LOG_LEVEL_TRACE
+	r.log_file = SRS_CONF_DEFAULT_LOG_FILE
+	r.listen = []string{ SRS_CONF_DEFAULT_LISTEN }
+	r.http_server = NewSrsConfHttpServer()
+	r.http_api = NewSrsConfHttpApi()
+	r.pithy_print = NewSrsConfPithyPrint()
+	r.vhosts = map[string]*SrsConfVhost{
+		SRS_CONF_DEFAULT_VHOST: NewSrsConfVhost(SRS_CONF_DEFAULT_VHOST),
+	}
+	return r
+}
+
+/**
+* the http_server section, the embeded http server.
+*/
+type SrsConfHttpServer struct {
+	// whether the http server is enabled.
+	enabled bool
+	// the listen endpoint, for example, 8080 or 127.0.0.1:8080
+	listen string
+	// the document root, for example, the path of hls.
+	dir string
+}
+func NewSrsConfHttpServer() (*SrsConfHttpServer) {
+	r := &SrsConfHttpServer{}
+	r.listen = SRS_CONF_DEFAULT_HTTP_SERVER_LISTEN
+	r.dir = SRS_CONF_DEFAULT_HTTP_SERVER_DIR
+	return r
+}
+
+/**
+* the http_api section, the http api to query the statistic of server.
+*/
+type SrsConfHttpApi struct {
+	// whether the http api is enabled.
+	enabled bool
+	// the listen endpoint, for example, 1985 or 127.0.0.1:1985
+	listen string
+	// the token to authenticate the admin api, admin api disabled when empty.
+	admin_token string
+}
+func NewSrsConfHttpApi() (*SrsConfHttpApi) {
+	r := &SrsConfHttpApi{}
+	r.listen = SRS_CONF_DEFAULT_HTTP_API_LISTEN
+	return r
+}
+
+/**
+* the pithy_print section, the interval in ms to print the
+* progress of clients for each stage, at most one line for a stage.
+*/
+type SrsConfPithyPrint struct {
+	publish_ms int
+	play_ms int
+	forwarder_ms int
+	edge_ms int
+}
+func NewSrsConfPithyPrint() (*SrsConfPithyPrint) {
+	r := &SrsConfPithyPrint{}
+	r.publish_ms = SRS_CONF_DEFAULT_PITHY_PRINT
+	r.play_ms = SRS_CONF_DEFAULT_PITHY_PRINT
+	r.forwarder_ms = SRS_CONF_DEFAULT_PITHY_PRINT
+	r.edge_ms = SRS_CONF_DEFAULT_PITHY_PRINT
+	return r
+}
+
+/**
+* get the log level of vhost, unknown to use the global log level.
+*/
+func (r *SrsConfVhost) LogLevel() (int) {
+	return int(atomic.LoadInt32(&r.log_level))
+}
+func (r *SrsConfVhost) SetLogLevel(level int) {
+	atomic.StoreInt32(&r.log_level, int32(level))
+}
+
+/**
+* get the config of vhost, use the __defaultVhost__ when not found.
+* @return the vhost config, nil if not found and no __defaultVhost__.
+*/
+func (r *SrsConfig) GetVhost(vhost string) (*SrsConfVhost) {
+	if v, ok := r.vhosts[vhost]; ok {
+		return v
+	}
+	if v, ok := r.vhosts[SRS_CONF_DEFAULT_VHOST]; ok {
+		return v
+	}
+	return nil
+}
+
+/**
+* parse the config file, for example, ./conf/srs.conf
+*/
+func (r *SrsConfig) ParseFile(file string) (err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(file); err != nil {
+		return SrsError{code:ERROR_SYSTEM_CONFIG_INVALID, desc:fmt.Sprintf("read config %v failed, err=%v", file, err)}
+	}
+
+	r.file = file
+	return r.parse(data)
+}
+func (r *SrsConfig) parse(data []byte) (err error) {
+	buf := &SrsConfBuffer{file:r.file, data:data, line:1}
+	if r.root, err = buf.parse_block(0, true); err != nil {
+		return
+	}
+
+	r.node_id = ""
+	r.log_tank = SRS_LOG_TANK_CONSOLE
+	r.log_format = SRS_LOG_FORMAT_TEXT
+	r.log_level = SRS_LOG_LEVEL_TRACE
+	r.log_file = SRS_CONF_DEFAULT_LOG_FILE
+	r.listen = nil
+	r.http_server = NewSrsConfHttpServer()
+	r.http_api = NewSrsConfHttpApi()
+	r.pithy_print = NewSrsConfPithyPrint()
+	r.vhosts = map[string]*SrsConfVhost{}
+
+	for _, d := range r.root.directives {
+		switch d.name {
+		case "node_id":
+			r.node_id = d.Arg0()
+		case "log_tank":
+			if r.log_tank = d.Arg0(); r.log_tank != SRS_LOG_TANK_CONSOLE && r.log_tank != SRS_LOG_TANK_FILE {
+				return buf.error(d.conf_line, fmt.Sprintf("invalid log_tank %v, must be %v or %v",
+					r.log_tank, SRS_LOG_TANK_CONSOLE, SRS_LOG_TANK_FILE))
+			}
+		case "log_format":
+			if r.log_format = d.Arg0(); r.log_format != SRS_LOG_FORMAT_TEXT && r.log_format != SRS_LOG_FORMAT_JSON {
+				return buf.error(d.conf_line, fmt.Sprintf("invalid log_format %v, must be %v or %v",
+					r.log_format, SRS_LOG_FORMAT_TEXT, SRS_LOG_FORMAT_JSON))
+			}
+		case "log_level":
+			if r.log_level, err = buf.parse_log_level(d); err != nil {
+				return
+			}
+		case "log_file":
+			r.log_file = d.Arg0()
+		case "listen":
+			if len(d.args) == 0 {
+				return buf.error(d.conf_line, "listen requires at least one port")
+			}
+			r.listen = append(r.listen, d.args...)
+		case "http_server":
+			if r.http_server, err = r.parse_http_server(buf, d); err != nil {
+				return
+			}
+		case "http_api":
+			if r.http_api, err = r.parse_http_api(buf, d); err != nil {
+				return
+			}
+		case "pithy_print":
+			if r.pithy_print, err = r.parse_pithy_print(buf, d); err != nil {
+				return
+			}
+		case "vhost":
+			var v *SrsConfVhost
+			if v, err = r.parse_vhost(buf, d); err != nil {
+				return
+			}
+			if _, ok := r.vhosts[v.name]; ok {
+				return buf.error(d.conf_line, fmt.Sprintf("duplicated vhost %v", v.name))
+			}
+			r.vhosts[v.name] = v
+		default:
+			return buf.unknown(d)
+		}
+	}
+
+	if len(r.listen) == 0 {
+		r.listen = []string{ SRS_CONF_DEFAULT_LISTEN }
+	}
+	return
+}
+func (r *SrsConfig) parse_http_server(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfHttpServer, err error) {
+	v = NewSrsConfHttpServer()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "listen":
+			if len(sd.args) != 1 {
+				return nil, buf.error(sd.conf_line, "http_server listen requires exactly one port")
+			}
+			v.listen = sd.Arg0()
+		case "dir":
+			v.dir = sd.Arg0()
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+func (r *SrsConfig) parse_http_api(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfHttpApi, err error) {
+	v = NewSrsConfHttpApi()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "listen":
+			if len(sd.args) != 1 {
+				return nil, buf.error(sd.conf_line, "http_api listen requires exactly one port")
+			}
+			v.listen = sd.Arg0()
+		case "admin_token":
+			v.admin_token = sd.Arg0()
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+func (r *SrsConfig) parse_pithy_print(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfPithyPrint, err error) {
+	v = NewSrsConfPithyPrint()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "publish":
+			if v.publish_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "play":
+			if v.play_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "forwarder":
+			if v.forwarder_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "edge":
+			if v.edge_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+func (r *SrsConfig) parse_vhost(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfVhost, err error) {
+	if len(d.args) != 1 {
+		return nil, buf.error(d.conf_line, "vhost requires exactly one name")
+	}
+	v = NewSrsConfVhost(d.Arg0())
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "ack_size":
+			var n int
+			if n, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+			v.ack_size = uint32(n)
+		case "peer_bandwidth":
+			var n int
+			if n, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+			v.peer_bandwidth = uint32(n)
+		case "queue_length":
+			if v.queue_length, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "queue_duration":
+			if v.queue_duration_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "chunk_size":
+			if v.chunk_size, err = buf.parse_int(sd, SRS_CONF_MIN_CHUNK_SIZE); err != nil {
+				return
+			}
+			if v.chunk_size > SRS_CONF_MAX_CHUNK_SIZE {
+				return nil, buf.error(sd.conf_line, fmt.Sprintf("invalid chunk_size %v, must in [%v, %v]",
+					v.chunk_size, SRS_CONF_MIN_CHUNK_SIZE, SRS_CONF_MAX_CHUNK_SIZE))
+			}
+		case "handshake_timeout":
+			if v.handshake_timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "connect_timeout":
+			if v.connect_timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "recv_timeout":
+			if v.recv_timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "send_timeout":
+			if v.send_timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "paused_recv_timeout":
+			if v.paused_recv_timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "paused_send_timeout":
+			if v.paused_send_timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "stream_busy_sleep":
+			if v.stream_busy_sleep_ms, err = buf.parse_int(sd, 0); err != nil {
+				return
+			}
+		case "publisher_takeover":
+			if v.publisher_takeover, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "refer":
+			v.refer = sd.args
+		case "refer_play":
+			v.refer_play = sd.args
+		case "refer_publish":
+			v.refer_publish = sd.args
+		case "forward":
+			v.forward = sd.args
+		case "hls":
+			if v.hls, err = r.parse_hls(buf, sd); err != nil {
+				return
+			}
+		case "transcode":
+			if v.transcode, err = r.parse_transcode(buf, sd); err != nil {
+				return
+			}
+		case "bandcheck":
+			if v.bandcheck, err = r.parse_bandcheck(buf, sd); err != nil {
+				return
+			}
+		case "http_hooks":
+			if v.http_hooks, err = r.parse_http_hooks(buf, sd); err != nil {
+				return
+			}
+		case "gop_cache":
+			if v.gop_cache, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "gop_cache_max_size":
+			if v.gop_cache_max_size, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "gop_cache_max_duration":
+			if v.gop_cache_max_duration_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "mode":
+			if v.mode = sd.Arg0(); v.mode != SRS_CONF_VHOST_MODE_ORIGIN && v.mode != SRS_CONF_VHOST_MODE_EDGE {
+				return nil, buf.error(sd.conf_line, fmt.Sprintf("invalid mode %v, must be %v or %v",
+					v.mode, SRS_CONF_VHOST_MODE_ORIGIN, SRS_CONF_VHOST_MODE_EDGE))
+			}
+		case "origin":
+			v.origin = sd.args
+		case "edge_idle_timeout":
+			if v.edge_idle_timeout_ms, err = buf.parse_int(sd, 0); err != nil {
+				return
+			}
+		case "source_idle_timeout":
+			if v.source_idle_timeout_ms, err = buf.parse_int(sd, 0); err != nil {
+				return
+			}
+		case "log_level":
+			var level int
+			if level, err = buf.parse_log_level(sd); err != nil {
+				return
+			}
+			v.log_level = int32(level)
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+
+	if v.IsEdge() && len(v.origin) == 0 {
+		return nil, buf.error(d.conf_line, fmt.Sprintf("edge vhost %v requires origin", v.name))
+	}
+	return
+}
+
+func (r *SrsConfig) parse_http_hooks(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfHttpHooks, err error) {
+	v = NewSrsConfHttpHooks()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "timeout":
+			if v.timeout_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "on_connect":
+			v.on_connect = sd.args
+		case "on_close":
+			v.on_close = sd.args
+		case "on_publish":
+			v.on_publish = sd.args
+		case "on_unpublish":
+			v.on_unpublish = sd.args
+		case "on_play":
+			v.on_play = sd.args
+		case "on_stop":
+			v.on_stop = sd.args
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+
+func (r *SrsConfig) parse_hls(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfHls, err error) {
+	v = NewSrsConfHls()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "path":
+			v.path = sd.Arg0()
+		case "fragment":
+			if v.fragment_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "window":
+			if v.window_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+func (r *SrsConfig) parse_transcode(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfTranscode, err error) {
+	v = NewSrsConfTranscode()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "engine":
+			if len(sd.args) == 0 {
+				return nil, buf.error(sd.conf_line, "engine requires the command line of encoder")
+			}
+			v.engines = append(v.engines, sd.args)
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+func (r *SrsConfig) parse_bandcheck(buf *SrsConfBuffer, d *SrsConfDirective) (v *SrsConfBandcheck, err error) {
+	v = NewSrsConfBandcheck()
+
+	for _, sd := range d.directives {
+		switch sd.name {
+		case "enabled":
+			if v.enabled, err = buf.parse_bool(sd); err != nil {
+				return
+			}
+		case "key":
+			v.key = sd.Arg0()
+		case "interval":
+			if v.interval_ms, err = buf.parse_int(sd, 0); err != nil {
+				return
+			}
+		case "duration":
+			if v.duration_ms, err = buf.parse_int(sd, 1); err != nil {
+				return
+			}
+		case "limit_kbps":
+			if v.limit_kbps, err = buf.parse_int(sd, 0); err != nil {
+				return
+			}
+		default:
+			return nil, buf.unknown(sd)
+		}
+	}
+	return
+}
+
+/**
+* the buffer to parse the config file.
+*/
+type SrsConfBuffer struct {
+	// the config file, for error message.
+	file string
+	// the content of config file.
+	data []byte
+	// the current position and line.
+	pos int
+	line int
+}
+func (r *SrsConfBuffer) error(line int, desc string) (error) {
+	return SrsError{code:ERROR_SYSTEM_CONFIG_INVALID, desc:fmt.Sprintf("%v:%v: %v", r.file, line, desc)}
+}
+/**
+* the error of unknown directive, for example, the misspelled directive.
+*/
+func (r *SrsConfBuffer) unknown(d *SrsConfDirective) (error) {
+	return r.error(d.conf_line, fmt.Sprintf("unknown directive %v", d.name))
+}
+/**
+* parse the arg0 of directive as integer, which must not less than min.
+*/
+func (r *SrsConfBuffer) parse_int(d *SrsConfDirective, min int) (v int, err error) {
+	if len(d.args) != 1 {
+		return 0, r.error(d.conf_line, fmt.Sprintf("%v requires exactly one arg", d.name))
+	}
+	if v, err = strconv.Atoi(d.Arg0()); err != nil || v < min {
+		return 0, r.error(d.conf_line, fmt.Sprintf("invalid %v %v, must be integer not less than %v", d.name, d.Arg0(), min))
+	}
+	return
+}
+/**
+* parse the arg0 of directive as bool, which must be on or off.
+*/
+func (r *SrsConfBuffer) parse_bool(d *SrsConfDirective) (v bool, err error) {
+	if len(d.args) != 1 || (d.Arg0() != "on" && d.Arg0() != "off") {
+		return false, r.error(d.conf_line, fmt.Sprintf("invalid %v, must be on or off", d.name))
+	}
+	return d.Arg0() == "on", nil
+}
+func (r *SrsConfBuffer) parse_log_level(d *SrsConfDirective) (v int, err error) {
+	var ok bool
+	if v, ok = SrsParseLogLevel(d.Arg0()); !ok || len(d.args) != 1 {
+		return SRS_LOG_LEVEL_UNKNOWN, r.error(d.conf_line, fmt.Sprintf("invalid %v, must be verbose, info, trace, warn or error", d.name))
+	}
+	return
+}
+/**
+* parse a block, the root when is_root, which ends with EOF,
+* otherwise the block ends with '}'.
+*/
+func (r *SrsConfBuffer) parse_block(line int, is_root bool) (block *SrsConfDirective, err error) {
+	block = &SrsConfDirective{conf_line:line}
+
+	var d *SrsConfDirective
+	for {
+		var token string
+		var token_line int
+		var eof, quoted bool
+		if token, token_line, eof, quoted, err = r.read_token(); err != nil {
+			return
+		}
+
+		if eof {
+			if d != nil {
+				return nil, r.error(d.conf_line, fmt.Sprintf("directive %v ends without ';'", d.name))
+			}
+			if !is_root {
+				return nil, r.error(line, "block starts with '{' but ends without '}'")
+			}
+			return
+		}
+
+		// the quoted token is always a name or arg, for example, "{"
+		if quoted {
+			if d == nil && token == "" {
+				return nil, r.error(token_line, "directive name is empty")
+			}
+			if d == nil {
+				d = &SrsConfDirective{conf_line:token_line, name:token}
+			} else {
+				d.args = append(d.args, token)
+			}
+			continue
+		}
+
+		switch token {
+		case ";":
+			if d == nil {
+				return nil, r.error(token_line, "unexpected ';'")
+			}
+			block.directives = append(block.directives, d)
+			d = nil
+		case "{":
+			if d == nil {
+				return nil, r.error(token_line, "unexpected '{'")
+			}
+			var sub *SrsConfDirective
+			if sub, err = r.parse_block(d.conf_line, false); err != nil {
+				return
+			}
+			d.directives = sub.directives
+			block.directives = append(block.directives, d)
+			d = nil
+		case "}":
+			if d != nil {
+				return nil, r.error(d.conf_line, fmt.Sprintf("directive %v ends without ';'", d.name))
+			}
+			if is_root {
+				return nil, r.error(token_line, "unexpected '}'")
+			}
+			return
+		default:
+			if d == nil {
+				d = &SrsConfDirective{conf_line:token_line, name:token}
+			} else {
+				d.args = append(d.args, token)
+			}
+		}
+	}
+	return
+}
+/**
+* read a token, where token is a word, a quoted string, or one of ";{}",
+* the comments starts with '#' to the end of line are ignored.
+*/
+func (r *SrsConfBuffer) read_token() (token string, line int, eof bool, quoted bool, err error) {
+	// skip the spaces and comments.
+	for r.pos < len(r.data) {
+		ch := r.data[r.pos]
+		if ch == '#' {
+			for r.pos < len(r.data) && r.data[r.pos] != '\n' {
+				r.pos++
+			}
+			continue
+		}
+		if ch != ' ' && ch != '\t' && ch != '\r' && ch != '\n' {
+			break
+		}
+		if ch == '\n' {
+			r.line++
+		}
+		r.pos++
+	}
+
+	line = r.line
+	if r.pos >= len(r.data) {
+		eof = true
+		return
+	}
+
+	ch := r.data[r.pos]
+	if ch == ';' || ch == '{' || ch == '}' {
+		r.pos++
+		return string(ch), line, false, false, nil
+	}
+
+	// quoted string
+	if ch == '"' || ch == '\'' {
+		start := r.pos + 1
+		for r.pos = start; r.pos < len(r.data) && r.data[r.pos] != ch; r.pos++ {
+			if r.data[r.pos] == '\n' {
+				r.line++
+			}
+		}
+		if r.pos >= len(r.data) {
+			return "", line, false, true, r.error(line, "quoted string ends without quote")
+		}
+		token = string(r.data[start:r.pos])
+		r.pos++
+		return token, line, false, true, nil
+	}
+
+	// word
+	start := r.pos
+	for r.pos < len(r.data) {
+		ch := r.data[r.pos]
+		if ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n' || ch == ';' || ch == '{' || ch == '}' || ch == '#' {
+			break
+		}
+		r.pos++
+	}
+	token = string(r.data[start:r.pos])
+	return
+}
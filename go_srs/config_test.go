@@ -0,0 +1,112 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func srs_test_parse_config(data string) (r *SrsConfig, err error) {
+	r = NewSrsConfig()
+	r.file = "test.conf"
+	err = r.parse([]byte(data))
+	return
+}
+
+func TestConfigParse(t *testing.T) {
+	r, err := srs_test_parse_config(`
+# the comment line.
+listen 1935 "127.0.0.1:19350"; # the comment after directive.
+vhost demo.srs.com {
+	gop_cache off;
+	refer "github.com" 'ossrs.net';
+	transcode {
+		enabled on;
+		engine ffmpeg -i "rtmp://127.0.0.1/live?vhost=[vhost]/[stream]" -f flv "{output}";
+	}
+}
+`)
+	if err != nil {
+		t.Fatalf("parse failed, err=%v", err)
+	}
+
+	if len(r.listen) != 2 || r.listen[1] != "127.0.0.1:19350" {
+		t.Errorf("listen %v invalid", r.listen)
+	}
+
+	v, ok := r.vhosts["demo.srs.com"]
+	if !ok {
+		t.Fatalf("vhost demo.srs.com not found")
+	}
+	if v.gop_cache {
+		t.Errorf("gop_cache should be off")
+	}
+	if len(v.refer) != 2 || v.refer[1] != "ossrs.net" {
+		t.Errorf("refer %v invalid", v.refer)
+	}
+	// the quoted "{output}" is an arg, not a block.
+	if engines := v.transcode.engines; len(engines) != 1 || engines[0][len(engines[0]) - 1] != "{output}" {
+		t.Errorf("engines %v invalid", engines)
+	}
+}
+
+func TestConfigParseError(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		// the error must contains the file:line and the desc.
+		err string
+	}{
+		{"unknown root directive", "listen 1935;\nlisten_port 1935;", "test.conf:2: unknown directive listen_port"},
+		{"unknown vhost directive", "vhost a {\n\tgop_cahce off;\n}", "test.conf:2: unknown directive gop_cahce"},
+		{"unknown sub block directive", "vhost a {\n\thls {\n\t\tenable on;\n\t}\n}", "test.conf:3: unknown directive enable"},
+		{"empty quoted name", "listen 1935;\n\"\";", "test.conf:2: directive name is empty"},
+		{"without semicolon", "listen 1935", "test.conf:1: directive listen ends without ';'"},
+		{"without semicolon in block", "vhost a {\n\tgop_cache off\n}", "test.conf:2: directive gop_cache ends without ';'"},
+		{"unexpected semicolon", "listen 1935;\n;", "test.conf:2: unexpected ';'"},
+		{"unexpected brace", "listen 1935;\n}", "test.conf:2: unexpected '}'"},
+		{"block without brace", "vhost a {\n\tgop_cache off;\n", "test.conf:1: block starts with '{' but ends without '}'"},
+		{"quoted without quote", "listen \"1935;\n", "test.conf:1: quoted string ends without quote"},
+		{"invalid bool", "vhost a {\n\tgop_cache yes;\n}", "test.conf:2: invalid gop_cache"},
+		{"invalid int", "vhost a {\n\tqueue_length 0;\n}", "test.conf:2: invalid queue_length 0"},
+		{"duplicated vhost", "vhost a {\n}\nvhost a {\n}", "test.conf:3: duplicated vhost a"},
+	}
+
+	for _, c := range cases {
+		_, err := srs_test_parse_config(c.data)
+		if err == nil {
+			t.Errorf("%v: should fail", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%v: error %v, expect %v", c.name, err, c.err)
+		}
+	}
+}
+
+func TestConfigParseDefaultFile(t *testing.T) {
+	r := NewSrsConfig()
+	if err := r.ParseFile("../conf/srs.conf"); err != nil {
+		t.Fatalf("parse the default config failed, err=%v", err)
+	}
+}
@@ -38,6 +38,10 @@ const SRS_PPROF_TIMEOUT_MS = 10*60*1000
 const SRS_PPROF_PULSE_MS = 800
 const SRS_PPROF_VHOST = "pprof"
 
+// the timeout to complete the handshake,
+// if timeout, close the connection.
+const SRS_HANDSHAKE_TIMEOUT_MS = 30*1000
+
 // the timeout to wait client data,
 // if timeout, close the connection.
 const SRS_SEND_TIMEOUT_MS = 30*1000
@@ -62,5 +66,20 @@ const SRS_STREAM_BUSY_SLEEP_MS = 3*1000
 // when error, forwarder sleep for a while and retry.
 const SRS_FORWARDER_SLEEP_MS = 3*1000
 
+// the interval to cleanup the idle sources.
+const SRS_SOURCE_REAP_INTERVAL_MS = 10*1000
+
+// the interval to sample the kbps of client and stream for http api.
+const SRS_KBPS_SAMPLE_MS = 10*1000
+
+// when error, edge ingester sleep for a while and retry the next origin.
+const SRS_EDGE_INGESTER_SLEEP_MS = 1*1000
+
 // when error, encoder sleep for a while and retry.
 const SRS_ENCODER_SLEEP_MS = 3*1000
+// the encoder sleep longer when quit again and again,
+// at most to this max sleep.
+const SRS_ENCODER_MAX_SLEEP_MS = 60*1000
+// the max length of a line of encoder output to log,
+// the longer line is split to lines.
+const SRS_ENCODER_MAX_LINE = 1024
@@ -0,0 +1,537 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+// the state of edge ingester.
+const SRS_EDGE_STATE_STOPPED = "stopped"
+const SRS_EDGE_STATE_CONNECTING = "connecting"
+const SRS_EDGE_STATE_INGESTING = "ingesting"
+
+/**
+* the edge ingester play the stream from origin for the edge source,
+* start when the first player comes, and stop when the last player
+* gone and idle for a while, try the next origin when failed.
+*/
+type SrsEdgeIngester struct {
+	id SrsLogId
+	source *SrsSource
+	// the lock for the state, never lock the source when hold it.
+	lock *sync.Mutex
+	// closed when stop the ingest, nil when not started.
+	stop chan bool
+	// closed when the ingest goroutine quit.
+	done chan bool
+	conn *net.TCPConn
+	// the timer to stop the ingest when idle.
+	idle *time.Timer
+	// the index of origin to ingest from.
+	origin int
+	state string
+}
+func NewSrsEdgeIngester(source *SrsSource) (*SrsEdgeIngester) {
+	r := &SrsEdgeIngester{}
+	r.id = SrsGenerateId()
+	r.source = source
+	r.lock = &sync.Mutex{}
+	r.state = SRS_EDGE_STATE_STOPPED
+	return r
+}
+
+// interface for Log
+func (r *SrsEdgeIngester) GetId() (SrsLogId) {
+	return r.id
+}
+func (r *SrsEdgeIngester) GetTag() (SrsLogTag) {
+	return "edge"
+}
+func (r *SrsEdgeIngester) GetLogVhost() (*SrsConfVhost) {
+	return r.source.vhost
+}
+
+/**
+* when player comes, start the ingest if not started,
+* and cancel the idle timer.
+*/
+func (r *SrsEdgeIngester) OnPlay() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.idle != nil {
+		r.idle.Stop()
+		r.idle = nil
+	}
+
+	if r.stop != nil {
+		return
+	}
+
+	// the previous ingest may not quit yet, wait for it in the new goroutine.
+	prev_done := r.done
+	r.stop = make(chan bool)
+	r.done = make(chan bool)
+	r.state = SRS_EDGE_STATE_CONNECTING
+
+	go r.cycle(prev_done, r.stop, r.done)
+}
+/**
+* when the last player gone, stop the ingest after idle timeout.
+* @param on_idle called when timeout, to check and stop the ingest.
+*/
+func (r *SrsEdgeIngester) OnIdle(timeout time.Duration, on_idle func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stop == nil || r.idle != nil {
+		return
+	}
+
+	SrsTrace(r, r, "no player, stop ingest after %v", timeout)
+	r.idle = time.AfterFunc(timeout, on_idle)
+}
+/**
+* stop the ingest, never block.
+*/
+func (r *SrsEdgeIngester) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.idle != nil {
+		r.idle.Stop()
+		r.idle = nil
+	}
+
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	r.stop = nil
+	r.state = SRS_EDGE_STATE_STOPPED
+
+	// interrupt the io of ingest.
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	SrsTrace(r, r, "stop ingest")
+}
+/**
+* get the state of ingest and the origin ingest from.
+*/
+func (r *SrsEdgeIngester) State() (state string, origin string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	origins := r.source.vhost.origin
+	return r.state, origins[r.origin % len(origins)]
+}
+func (r *SrsEdgeIngester) set_state(stop chan bool, state string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// ignore the state of stopped ingest.
+	if r.stop == stop {
+		r.state = state
+	}
+}
+
+func (r *SrsEdgeIngester) cycle(prev_done chan bool, stop chan bool, done chan bool) {
+	defer close(done)
+
+	if prev_done != nil {
+		<- prev_done
+	}
+
+	origins := r.source.vhost.origin
+	for {
+		select {
+		case <- stop:
+			return
+		default:
+		}
+
+		r.set_state(stop, SRS_EDGE_STATE_CONNECTING)
+
+		r.lock.Lock()
+		origin := origins[r.origin % len(origins)]
+		r.lock.Unlock()
+
+		if err := r.ingest(origin, stop); err != nil {
+			select {
+			case <- stop:
+				return
+			default:
+			}
+			SrsWarn(r, r, "ingest from origin %v failed, try next after %vms, err=%v", origin, SRS_EDGE_INGESTER_SLEEP_MS, err)
+		}
+
+		// failover to the next origin.
+		r.lock.Lock()
+		r.origin = (r.origin + 1) % len(origins)
+		r.lock.Unlock()
+
+		select {
+		case <- stop:
+			return
+		case <- time.After(SRS_EDGE_INGESTER_SLEEP_MS * time.Millisecond):
+		}
+	}
+}
+func (r *SrsEdgeIngester) ingest(origin string, stop chan bool) (err error) {
+	ep := origin
+	if !strings.Contains(ep, ":") {
+		ep = ep + ":" + SRS_CONF_DEFAULT_LISTEN
+	}
+
+	var client rtmp.Client
+	connect_timeout := time.Duration(r.source.vhost.connect_timeout_ms) * time.Millisecond
+	if client, _, err = srs_rtmp_connect(ep, connect_timeout, r.lock, &r.conn, stop); err != nil {
+		return
+	}
+	defer client.Destroy()
+
+	req := r.source.req
+	app, tc_url := srs_rtmp_upstream_app(ep, req, r.GetId())
+	if err = client.Handshake(); err != nil {
+		return
+	}
+	if err = client.ConnectApp(app, tc_url); err != nil {
+		return
+	}
+
+	var stream_id uint32
+	if stream_id, err = client.CreateStream(); err != nil {
+		return
+	}
+	if err = client.Play(req.Stream, stream_id); err != nil {
+		return
+	}
+
+	// the ingest is the publisher of edge source.
+	r.source.OnPublish()
+	defer r.source.OnUnpublish()
+
+	r.set_state(stop, SRS_EDGE_STATE_INGESTING)
+	SrsTrace(r, r, "ingesting from %v, tcUrl=%v, stream=%v", ep, tc_url, req.Stream)
+
+	pithy_print := NewSrsPithyPrint(SRS_STAGE_EDGE)
+	defer pithy_print.Close()
+
+	timeout := time.Duration(r.source.vhost.recv_timeout_ms) * time.Millisecond
+	msg_input_channel := client.Protocol().MessageInputChannel()
+	for {
+		select {
+		case <- stop:
+			return
+		case msg, ok := <- msg_input_channel:
+			if !ok {
+				return SrsError{code:ERROR_EDGE_INGEST_CLOSED, desc:"origin closed"}
+			}
+			if err = r.process_message(msg); err != nil {
+				return
+			}
+
+			pithy_print.OnRecv(len(msg.Payload))
+			if pithy_print.CanPrint() {
+				SrsTrace(r, r, "<- edge %v, origin=%v, time=%v", pithy_print, origin, msg.Header.Timestamp)
+			}
+		case <- time.After(timeout):
+			return SrsError{code:ERROR_EDGE_INGEST_CLOSED, desc:fmt.Sprintf("no data from origin for %v", timeout)}
+		}
+	}
+	return
+}
+func (r *SrsEdgeIngester) process_message(msg *rtmp.Message) (err error) {
+	if msg.Header.IsAudio() {
+		return r.source.OnAudio(msg)
+	}
+	if msg.Header.IsVideo() {
+		return r.source.OnVideo(msg)
+	}
+	if msg.Header.IsAmf0Data() || msg.Header.IsAmf3Data() {
+		return r.source.OnMetaData(msg)
+	}
+	// ignore the command messages, for example, the onStatus.
+	return
+}
+
+/**
+* the edge proxy publish the stream of encoder to origin,
+* try the next origin when failed, and relay the error of origin
+* to encoder, for example, the stream is busy.
+*/
+type SrsEdgeProxy struct {
+	client *SrsClient
+	// the lock for the connection.
+	lock *sync.Mutex
+	// closed when close the proxy.
+	stop chan bool
+	conn *net.TCPConn
+	// the rtmp client connected to origin.
+	origin rtmp.Client
+	stream_id uint32
+	// the messages from origin.
+	msgs chan *rtmp.Message
+	// the error status of origin, relayed to encoder by the client goroutine,
+	// which owns the protocol of encoder.
+	status *SrsEdgeStatus
+}
+/**
+* the onStatus of origin.
+*/
+type SrsEdgeStatus struct {
+	level string
+	code string
+	desc string
+}
+func NewSrsEdgeProxy(client *SrsClient) (*SrsEdgeProxy) {
+	r := &SrsEdgeProxy{}
+	r.client = client
+	r.lock = &sync.Mutex{}
+	r.stop = make(chan bool)
+	return r
+}
+
+// interface for Log
+func (r *SrsEdgeProxy) GetId() (SrsLogId) {
+	return r.client.GetId()
+}
+func (r *SrsEdgeProxy) GetTag() (SrsLogTag) {
+	return "edge"
+}
+func (r *SrsEdgeProxy) GetLogVhost() (*SrsConfVhost) {
+	return r.client.GetLogVhost()
+}
+
+/**
+* connect to the origins one by one, until publish success,
+* the next origin is tried only when connect or transport failed,
+* the error status of origin is relayed to encoder.
+*/
+func (r *SrsEdgeProxy) Connect() (err error) {
+	for _, origin := range r.client.vhost.origin {
+		if err = r.connect(origin); err == nil {
+			go r.cycle()
+			return
+		}
+		if re, ok := err.(SrsError); ok && re.code == ERROR_EDGE_ORIGIN_REJECTED {
+			return
+		}
+		SrsWarn(r, r, "proxy publish to origin %v failed, try next, err=%v", origin, err)
+	}
+	return
+}
+/**
+* get the error status of origin, nil if origin never rejects.
+*/
+func (r *SrsEdgeProxy) Status() (*SrsEdgeStatus) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.status
+}
+/**
+* proxy the audio, video and data message of encoder to origin.
+*/
+func (r *SrsEdgeProxy) Proxy(msg *rtmp.Message) (err error) {
+	if status := r.Status(); status != nil {
+		return SrsError{code:ERROR_EDGE_ORIGIN_REJECTED, desc:fmt.Sprintf("origin error, code=%v, desc=%v", status.code, status.desc)}
+	}
+
+	if !msg.Header.IsAudio() && !msg.Header.IsVideo() && !msg.Header.IsAmf0Data() && !msg.Header.IsAmf3Data() {
+		return
+	}
+
+	// the conn is set by connect in the client goroutine, which proxy the messages.
+	r.conn.SetWriteDeadline(time.Now().Add(time.Duration(r.client.vhost.send_timeout_ms) * time.Millisecond))
+	return r.origin.Protocol().SendMessage(msg, r.stream_id)
+}
+/**
+* close the connection to origin, never block.
+*/
+func (r *SrsEdgeProxy) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	select {
+	case <- r.stop:
+		return
+	default:
+	}
+	close(r.stop)
+
+	if r.origin != nil {
+		r.origin.Destroy()
+	} else if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+func (r *SrsEdgeProxy) connect(origin string) (err error) {
+	ep := origin
+	if !strings.Contains(ep, ":") {
+		ep = ep + ":" + SRS_CONF_DEFAULT_LISTEN
+	}
+
+	var client rtmp.Client
+	var conn *net.TCPConn
+	connect_timeout := time.Duration(r.client.vhost.connect_timeout_ms) * time.Millisecond
+	if client, conn, err = srs_rtmp_connect(ep, connect_timeout, r.lock, &r.conn, r.stop); err != nil {
+		return
+	}
+
+	// the handshake, connect and publish must complete in the connect timeout,
+	// or try the next origin.
+	conn.SetDeadline(time.Now().Add(connect_timeout))
+
+	req := r.client.req
+	app, tc_url := srs_rtmp_upstream_app(ep, req, r.GetId())
+	if err = client.Handshake(); err == nil {
+		if err = client.ConnectApp(app, tc_url); err == nil {
+			if r.stream_id, err = client.CreateStream(); err == nil {
+				if err = client.Publish(req.Stream, r.stream_id); err == nil {
+					r.msgs = client.Protocol().MessageInputChannel()
+					err = r.wait_publish()
+				}
+			}
+		}
+	}
+	if err != nil {
+		client.Destroy()
+		return
+	}
+
+	// the origin may send nothing, never timeout the recv,
+	// while each proxy message must be sent in the send timeout.
+	conn.SetDeadline(time.Time{})
+
+	r.lock.Lock()
+	r.origin = client
+	r.lock.Unlock()
+
+	SrsTrace(r, r, "proxy publish to %v, tcUrl=%v, stream=%v", ep, tc_url, req.Stream)
+	return
+}
+/**
+* wait for the onStatus of origin for the publish,
+* the error status is kept to relay to encoder.
+*/
+func (r *SrsEdgeProxy) wait_publish() (err error) {
+	timeout := time.After(r.client.recv_timeout)
+	for {
+		select {
+		case <- r.stop:
+			return SrsError{code:ERROR_EDGE_INGEST_CLOSED, desc:"proxy closed"}
+		case <- timeout:
+			return SrsError{code:ERROR_EDGE_INGEST_CLOSED, desc:"wait publish status of origin timeout"}
+		case msg, ok := <- r.msgs:
+			if !ok {
+				return SrsError{code:ERROR_EDGE_INGEST_CLOSED, desc:"origin closed"}
+			}
+			if !msg.Header.IsAmf0Command() && !msg.Header.IsAmf3Command() {
+				continue
+			}
+
+			level, code, desc := srs_edge_decode_status(msg)
+			if level == "" {
+				continue
+			}
+			if level == SRS_STATUS_LEVEL_ERROR {
+				return r.reject(level, code, desc)
+			}
+			return
+		}
+	}
+	return
+}
+/**
+* keep the error status of origin, for the client goroutine to relay.
+*/
+func (r *SrsEdgeProxy) reject(level string, code string, desc string) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.status = &SrsEdgeStatus{level:level, code:code, desc:desc}
+	SrsWarn(r, r, "origin reject publish, code=%v, desc=%v", code, desc)
+	return SrsError{code:ERROR_EDGE_ORIGIN_REJECTED, desc:fmt.Sprintf("origin error, code=%v, desc=%v", code, desc)}
+}
+/**
+* recv the messages of origin, kick the encoder when origin closed,
+* or keep the error status of origin, which is relayed to encoder
+* by the client goroutine when proxy the next message.
+*/
+func (r *SrsEdgeProxy) cycle() {
+	for msg := range r.msgs {
+		if !msg.Header.IsAmf0Command() && !msg.Header.IsAmf3Command() {
+			continue
+		}
+
+		level, code, desc := srs_edge_decode_status(msg)
+		if level != SRS_STATUS_LEVEL_ERROR {
+			continue
+		}
+
+		r.reject(level, code, desc)
+		return
+	}
+
+	select {
+	case <- r.stop:
+	default:
+		r.client.Kick("origin closed")
+	}
+}
+/**
+* decode the level, code and description of onStatus, empty if not onStatus.
+*/
+func srs_edge_decode_status(msg *rtmp.Message) (level string, code string, desc string) {
+	payload := msg.Payload
+	if msg.Header.IsAmf3Command() && len(payload) > 0 {
+		payload = payload[1:]
+	}
+
+	dec := NewSrsAmf0Decoder(payload)
+	if call, err := dec.ReadString(); err != nil || call != "onStatus" {
+		return
+	}
+
+	// the transaction id and null command object.
+	var v interface{}
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		v, err = dec.ReadAny()
+	}
+	if data, ok := v.(map[string]interface{}); ok && err == nil {
+		level, _ = data["level"].(string)
+		code, _ = data["code"].(string)
+		desc, _ = data["description"].(string)
+	}
+	return
+}
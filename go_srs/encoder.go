@@ -0,0 +1,194 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+/**
+* the encoder run an external encoder process for the stream,
+* for example, the ffmpeg to transcode the stream, restart when quit.
+*/
+type SrsEncoder struct {
+	id SrsLogId
+	source *SrsSource
+	// the command line template of encoder.
+	engine []string
+	// the command line of encoder, the template replaced by stream.
+	args []string
+	// the sleep before restart, doubled when quit again until the max.
+	sleep_ms int
+	max_sleep_ms int
+	// closed when stop the encoder.
+	stop chan bool
+	// the lock for the process.
+	lock *sync.Mutex
+	cmd *exec.Cmd
+}
+func NewSrsEncoder(source *SrsSource, engine []string) (*SrsEncoder) {
+	r := &SrsEncoder{}
+	r.id = SrsGenerateId()
+	r.source = source
+	r.engine = engine
+	r.sleep_ms = SRS_ENCODER_SLEEP_MS
+	r.max_sleep_ms = SRS_ENCODER_MAX_SLEEP_MS
+	r.stop = make(chan bool)
+	r.lock = &sync.Mutex{}
+	return r
+}
+
+// interface for Log
+func (r *SrsEncoder) GetId() (SrsLogId) {
+	return r.id
+}
+func (r *SrsEncoder) GetTag() (SrsLogTag) {
+	return "encoder"
+}
+func (r *SrsEncoder) GetLogVhost() (*SrsConfVhost) {
+	return r.source.vhost
+}
+
+/**
+* start the encoder for the stream of request, in a goroutine.
+*/
+func (r *SrsEncoder) OnPublish(req *rtmp.Request) {
+	replacer := strings.NewReplacer("[vhost]", req.Vhost, "[app]", req.App, "[stream]", req.Stream)
+	for _, arg := range r.engine {
+		r.args = append(r.args, replacer.Replace(arg))
+	}
+
+	SrsTrace(r, r, "start encoder for %v, cmd=%v", req.StreamUrl(), strings.Join(r.args, " "))
+	go r.cycle()
+}
+/**
+* stop the encoder, kill the process group and never block,
+* for the encoder may fork children, for instance, the shell.
+*/
+func (r *SrsEncoder) OnUnpublish() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	select {
+	case <- r.stop:
+		return
+	default:
+	}
+	close(r.stop)
+
+	if r.cmd != nil && r.cmd.Process != nil {
+		syscall.Kill(-r.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	SrsTrace(r, r, "stop encoder %v", r.args[0])
+}
+
+func (r *SrsEncoder) cycle() {
+	sleep := time.Duration(r.sleep_ms) * time.Millisecond
+	max_sleep := time.Duration(r.max_sleep_ms) * time.Millisecond
+
+	for {
+		start := time.Now()
+		err := r.run()
+
+		select {
+		case <- r.stop:
+			return
+		default:
+		}
+
+		// the encoder runs for a long time, restart quickly,
+		// otherwise sleep longer when quit again and again.
+		if time.Now().Sub(start) > max_sleep {
+			sleep = time.Duration(r.sleep_ms) * time.Millisecond
+		}
+		SrsWarn(r, r, "encoder %v quit, restart after %v, err=%v", r.args[0], sleep, err)
+
+		select {
+		case <- r.stop:
+			return
+		case <- time.After(sleep):
+		}
+
+		if sleep *= 2; sleep > max_sleep {
+			sleep = max_sleep
+		}
+	}
+}
+func (r *SrsEncoder) run() (err error) {
+	cmd := exec.Command(r.args[0], r.args[1:]...)
+	// the encoder in its own process group, to kill all its children.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid:true}
+	// log the output of encoder, to find out why it quit.
+	output := &SrsEncoderOutput{encoder:r}
+	cmd.Stdout, cmd.Stderr = output, output
+	defer output.flush()
+
+	// start the process under the lock,
+	// for the stop to kill it or never start.
+	r.lock.Lock()
+	select {
+	case <- r.stop:
+		r.lock.Unlock()
+		return
+	default:
+	}
+	if err = cmd.Start(); err != nil {
+		r.lock.Unlock()
+		return
+	}
+	r.cmd = cmd
+	r.lock.Unlock()
+
+	SrsTrace(r, r, "encoder %v started, pid=%v", r.args[0], cmd.Process.Pid)
+	return cmd.Wait()
+}
+
+/**
+* the output of encoder, log each line, the \r and \n ends a line,
+* for instance, the progress of ffmpeg ends by \r.
+*/
+type SrsEncoderOutput struct {
+	encoder *SrsEncoder
+	line []byte
+}
+func (r *SrsEncoderOutput) Write(p []byte) (n int, err error) {
+	for _, b := range p {
+		if b != '\n' && b != '\r' {
+			r.line = append(r.line, b)
+		}
+		if b == '\n' || b == '\r' || len(r.line) >= SRS_ENCODER_MAX_LINE {
+			r.flush()
+		}
+	}
+	return len(p), nil
+}
+func (r *SrsEncoderOutput) flush() {
+	if len(r.line) > 0 {
+		SrsTrace(r.encoder, r.encoder, "%v: %v", r.encoder.args[0], string(r.line))
+		r.line = r.line[:0]
+	}
+}
@@ -0,0 +1,199 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+/**
+* write the shell stub as the encoder engine, return the dir of stub.
+*/
+func srs_test_encoder_stub(t *testing.T, script string) (dir string, engine string) {
+	dir, err := ioutil.TempDir("", "srs-encoder")
+	if err != nil {
+		t.Fatalf("create dir failed, err=%v", err)
+	}
+
+	engine = filepath.Join(dir, "engine.sh")
+	if err = ioutil.WriteFile(engine, []byte("#!/bin/sh\n" + script), 0755); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("write engine failed, err=%v", err)
+	}
+	return
+}
+
+/**
+* the source of encoder, for the log level of vhost.
+*/
+func srs_test_encoder_source() (*SrsSource) {
+	return &SrsSource{id:SrsGenerateId(), vhost:NewSrsConfVhost("a.srs.com")}
+}
+
+/**
+* wait for the file to have at least n lines, return the lines.
+*/
+func srs_test_wait_lines(t *testing.T, file string, n int, timeout time.Duration) ([]string) {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		data, _ := ioutil.ReadFile(file)
+		if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(data) > 0 && len(lines) >= n {
+			return lines
+		}
+		if time.Since(start) > timeout {
+			t.Fatalf("wait for %v lines of %v timeout, got %q", n, file, string(data))
+		}
+	}
+}
+
+/**
+* whether the process is alive, the zombie is dead.
+*/
+func srs_test_process_alive(pid int) (bool) {
+	data, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// the state follows the comm in parenthesis, for example, "1 (sh) S ...".
+	fields := strings.Fields(string(data[strings.LastIndex(string(data), ")") + 1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestEncoderRestart(t *testing.T) {
+	dir, engine := srs_test_encoder_stub(t, `echo "$@" >> "$1"; date +%s%N >> "$1.start"`)
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "encoder.log")
+	r := NewSrsEncoder(srs_test_encoder_source(), []string{ engine, log, "rtmp://127.0.0.1/[app]?vhost=[vhost]/[stream]", "[stream]_sd" })
+	r.sleep_ms, r.max_sleep_ms = 100, 300
+	r.OnPublish(&rtmp.Request{Vhost:"a.srs.com", App:"live", Stream:"livestream"})
+	defer r.OnUnpublish()
+
+	lines := srs_test_wait_lines(t, log, 4, 10 * time.Second)
+	for _, line := range lines {
+		if expect := log + " rtmp://127.0.0.1/live?vhost=a.srs.com/livestream livestream_sd"; line != expect {
+			t.Errorf("args %q, expect %q", line, expect)
+		}
+	}
+
+	// the sleep is 100ms, then doubled to 200ms, then the max 300ms.
+	starts := srs_test_wait_lines(t, log + ".start", 4, 10 * time.Second)
+	for i, sleep := range []time.Duration{ 100, 200, 300 } {
+		prev, _ := strconv.ParseInt(starts[i], 10, 64)
+		next, _ := strconv.ParseInt(starts[i + 1], 10, 64)
+		if elapse := time.Duration(next - prev); elapse < sleep * time.Millisecond {
+			t.Errorf("restart %v after %v, expect sleep %vms", i, elapse, int(sleep))
+		}
+	}
+}
+
+func TestEncoderKill(t *testing.T) {
+	// the engine forks a child, which must be killed with the engine.
+	dir, engine := srs_test_encoder_stub(t, `sleep 1000 & echo $$ $! >> "$1"; wait`)
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "encoder.log")
+	r := NewSrsEncoder(srs_test_encoder_source(), []string{ engine, log })
+	r.sleep_ms, r.max_sleep_ms = 100, 100
+	r.OnPublish(&rtmp.Request{Vhost:"a.srs.com", App:"live", Stream:"livestream"})
+
+	pids := []int{}
+	for _, v := range strings.Fields(srs_test_wait_lines(t, log, 1, 10 * time.Second)[0]) {
+		pid, _ := strconv.Atoi(v)
+		pids = append(pids, pid)
+	}
+	if len(pids) != 2 {
+		t.Fatalf("invalid pids %v", pids)
+	}
+
+	r.OnUnpublish()
+	for start := time.Now(); srs_test_process_alive(pids[0]) || srs_test_process_alive(pids[1]); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10 * time.Second {
+			t.Fatalf("encoder %v or child %v alive after unpublish", pids[0], pids[1])
+		}
+	}
+
+	// never restart after unpublish.
+	time.Sleep(3 * time.Duration(r.sleep_ms) * time.Millisecond)
+	if lines := srs_test_wait_lines(t, log, 1, time.Second); len(lines) != 1 {
+		t.Errorf("encoder restarted after unpublish, %v", lines)
+	}
+}
+
+func TestEncoderOutput(t *testing.T) {
+	dir, engine := srs_test_encoder_stub(t, `echo "$1 started"; printf "$1 progress 1\r$1 progress 2\r" >&2; sleep 1000`)
+	defer os.RemoveAll(dir)
+
+	// log to file, restore to console when done.
+	log := filepath.Join(dir, "srs.log")
+	if err := srs_log.Open(SRS_LOG_TANK_FILE, log); err != nil {
+		t.Fatalf("open log failed, err=%v", err)
+	}
+	defer func() {
+		srs_log.lock.Lock()
+		defer srs_log.lock.Unlock()
+
+		srs_log.f.Close()
+		srs_log.f, srs_log.tank = nil, SRS_LOG_TANK_CONSOLE
+	}()
+
+	// the encoder of vhost which log level is error, never log its output.
+	quiet := srs_test_encoder_source()
+	quiet.vhost.SetLogLevel(SRS_LOG_LEVEL_ERROR)
+
+	encoders := []*SrsEncoder{
+		NewSrsEncoder(srs_test_encoder_source(), []string{ engine, "loud" }),
+		NewSrsEncoder(quiet, []string{ engine, "quiet" }),
+	}
+	for _, r := range encoders {
+		r.OnPublish(&rtmp.Request{Vhost:"a.srs.com", App:"live", Stream:"livestream"})
+		defer r.OnUnpublish()
+	}
+
+	lines := []string{ "loud started", "loud progress 1", "loud progress 2" }
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		data, _ := ioutil.ReadFile(log)
+
+		found := 0
+		for _, line := range lines {
+			if strings.Contains(string(data), engine + ": " + line + "\n") {
+				found++
+			}
+		}
+		if found == len(lines) {
+			if strings.Contains(string(data), "quiet") {
+				t.Errorf("the output of quiet vhost should not be logged\n%v", string(data))
+			}
+			break
+		}
+
+		if time.Since(start) > 10 * time.Second {
+			t.Fatalf("encoder output %q not logged\n%v", lines, string(data))
+		}
+	}
+}
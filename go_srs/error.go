@@ -28,6 +28,56 @@ import "fmt"
 // sys ctl: rtmp close stream, support replay.
 const ERROR_CONTROL_RTMP_CLOSE = 100
 
+// system error.
+// the config file is invalid, or failed to read.
+const ERROR_SYSTEM_CONFIG_INVALID = 409
+// the stream is already publishing by another client.
+const ERROR_SYSTEM_STREAM_BUSY = 410
+// the bandcheck key of client is invalid.
+const ERROR_SYSTEM_BANDWIDTH_KEY = 411
+// the bandcheck is denied, for example, client check too frequently.
+const ERROR_SYSTEM_BANDWIDTH_DENIED = 412
+
+// rtmp error.
+// the vhost of client not found in config.
+const ERROR_RTMP_VHOST_NOT_FOUND = 315
+// the vhost of client is disabled.
+const ERROR_RTMP_VHOST_DISABLED = 316
+// the client is denied, for example, refer check failed.
+const ERROR_RTMP_ACCESS_DENIED = 317
+// the app or stream name is invalid, for example, contains the .. or /.
+const ERROR_RTMP_NAME_INVALID = 318
+
+// forwarder error.
+// the forwarder is stopped, or the destination closed the connection.
+const ERROR_FORWARDER_CLOSED = 500
+
+// edge error.
+// the ingest is stopped, or the origin closed or timeout.
+const ERROR_EDGE_INGEST_CLOSED = 510
+// the origin rejects the publish of edge proxy, for example, the stream is busy.
+const ERROR_EDGE_ORIGIN_REJECTED = 511
+
+// codec error.
+// the onMetaData is invalid, failed to decode.
+const ERROR_CODEC_METADATA_INVALID = 600
+// the h.264 sequence header or nalus is invalid.
+const ERROR_CODEC_AVC_INVALID = 601
+// the aac sequence header or raw data is invalid.
+const ERROR_CODEC_AAC_INVALID = 602
+// the amf0 object or array nested too deep.
+const ERROR_CODEC_AMF0_TOO_DEEP = 603
+
+// hls error.
+// failed to write the ts or m3u8 file.
+const ERROR_HLS_WRITE_FAILED = 700
+
+// http error.
+// the http hooks failed, for example, timeout or response invalid.
+const ERROR_HTTP_HOOKS_FAILED = 800
+// the http hooks response with non-zero code.
+const ERROR_HTTP_HOOKS_DENIED = 801
+
 /**
 * whether the error code is an system control error.
 */
@@ -0,0 +1,288 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+// the query of app, the client id of downstream server.
+const SRS_UPSTREAM_CID = "srs_cid"
+
+// the state of forwarder.
+const SRS_FORWARDER_STATE_CONNECTING = "connecting"
+const SRS_FORWARDER_STATE_FORWARDING = "forwarding"
+const SRS_FORWARDER_STATE_ERROR = "error"
+const SRS_FORWARDER_STATE_STOPPED = "stopped"
+
+/**
+* the forwarder forward the stream to another rtmp server,
+* which is an internal consumer of source, and retry when error.
+*/
+type SrsForwarder struct {
+	id SrsLogId
+	source *SrsSource
+	// the destination, for example, 127.0.0.1:1936
+	destination string
+	// the stream to forward, copy of the request of source.
+	req *rtmp.Request
+	// closed when stop the forwarder.
+	stop chan bool
+	// the lock for the state and the connection.
+	lock *sync.Mutex
+	conn *net.TCPConn
+	// the state, bytes sent and last error of forwarder.
+	state string
+	send_bytes uint64
+	last_error error
+}
+func NewSrsForwarder(source *SrsSource, destination string) (*SrsForwarder) {
+	r := &SrsForwarder{}
+	r.id = SrsGenerateId()
+	r.source = source
+	r.destination = destination
+	r.stop = make(chan bool)
+	r.lock = &sync.Mutex{}
+	r.state = SRS_FORWARDER_STATE_STOPPED
+	return r
+}
+
+// interface for Log
+func (r *SrsForwarder) GetId() (SrsLogId) {
+	return r.id
+}
+func (r *SrsForwarder) GetTag() (SrsLogTag) {
+	return "forwarder"
+}
+func (r *SrsForwarder) GetLogVhost() (*SrsConfVhost) {
+	return r.source.vhost
+}
+
+/**
+* start to forward the stream of request, in a goroutine.
+*/
+func (r *SrsForwarder) OnPublish(req *rtmp.Request) {
+	r.req = &rtmp.Request{}
+	*r.req = *req
+
+	SrsTrace(r, r, "start forward %v to %v", req.StreamUrl(), r.destination)
+	go r.cycle()
+}
+/**
+* stop the forwarder, never block.
+*/
+func (r *SrsForwarder) OnUnpublish() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	select {
+	case <- r.stop:
+		return
+	default:
+	}
+	close(r.stop)
+
+	// interrupt the io of forwarder.
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	SrsTrace(r, r, "stop forward to %v", r.destination)
+}
+func (r *SrsForwarder) stopped() (bool) {
+	select {
+	case <- r.stop:
+		return true
+	default:
+	}
+	return false
+}
+func (r *SrsForwarder) set_state(state string, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.state = state
+	if err != nil {
+		r.last_error = err
+	}
+}
+/**
+* get the state, bytes sent and last error of forwarder.
+*/
+func (r *SrsForwarder) State() (state string, send_bytes uint64, last_error error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.state, r.send_bytes, r.last_error
+}
+
+func (r *SrsForwarder) cycle() {
+	defer r.set_state(SRS_FORWARDER_STATE_STOPPED, nil)
+
+	for !r.stopped() {
+		r.set_state(SRS_FORWARDER_STATE_CONNECTING, nil)
+
+		if err := r.forward(); err != nil && !r.stopped() {
+			r.set_state(SRS_FORWARDER_STATE_ERROR, err)
+			SrsWarn(r, r, "forward to %v failed, retry after %vms, err=%v", r.destination, SRS_FORWARDER_SLEEP_MS, err)
+		}
+
+		select {
+		case <- r.stop:
+		case <- time.After(SRS_FORWARDER_SLEEP_MS * time.Millisecond):
+		}
+	}
+}
+func (r *SrsForwarder) forward() (err error) {
+	ep := r.destination
+	if !strings.Contains(ep, ":") {
+		ep = ep + ":" + SRS_CONF_DEFAULT_LISTEN
+	}
+
+	vhost := r.source.vhost
+	connect_timeout := time.Duration(vhost.connect_timeout_ms) * time.Millisecond
+	send_timeout := time.Duration(vhost.send_timeout_ms) * time.Millisecond
+
+	var client rtmp.Client
+	var conn *net.TCPConn
+	if client, conn, err = srs_rtmp_connect(ep, connect_timeout, r.lock, &r.conn, r.stop); err != nil {
+		return
+	}
+	defer client.Destroy()
+
+	// the handshake, connect and publish must complete in the connect timeout.
+	conn.SetDeadline(time.Now().Add(connect_timeout))
+
+	// the tcUrl of destination, use the vhost of source.
+	app, tc_url := srs_rtmp_upstream_app(ep, r.req, r.GetId())
+	if err = client.Handshake(); err != nil {
+		return
+	}
+	if err = client.ConnectApp(app, tc_url); err != nil {
+		return
+	}
+
+	var stream_id uint32
+	if stream_id, err = client.CreateStream(); err != nil {
+		return
+	}
+	if err = client.Publish(r.req.Stream, stream_id); err != nil {
+		return
+	}
+
+	// the destination may send nothing, never timeout the recv,
+	// while each send must complete in the send timeout.
+	conn.SetDeadline(time.Time{})
+
+	// the forwarder is a consumer of source.
+	var consumer *SrsConsumer
+	if consumer, err = r.source.CreateConsumer(); err != nil {
+		return
+	}
+	defer consumer.Close()
+
+	r.set_state(SRS_FORWARDER_STATE_FORWARDING, nil)
+	SrsTrace(r, r, "forwarding to %v, tcUrl=%v, stream=%v", ep, tc_url, r.req.Stream)
+
+	pithy_print := NewSrsPithyPrint(SRS_STAGE_FORWARDER)
+	defer pithy_print.Close()
+
+	msg_input_channel := client.Protocol().MessageInputChannel()
+	msg_send_channel := consumer.Wait()
+	for {
+		select {
+		case <- r.stop:
+			return
+		case _, ok := <- msg_input_channel:
+			// ignore the control messages from server.
+			if !ok {
+				return SrsError{code:ERROR_FORWARDER_CLOSED, desc:"destination closed"}
+			}
+		case <- msg_send_channel:
+			msgs := consumer.Fetch()
+			for _, msg := range msgs {
+				conn.SetWriteDeadline(time.Now().Add(send_timeout))
+				if err = client.Protocol().SendMessage(msg, stream_id); err != nil {
+					return
+				}
+				pithy_print.OnSend(len(msg.Payload))
+
+				r.lock.Lock()
+				r.send_bytes += uint64(len(msg.Payload))
+				r.lock.Unlock()
+			}
+
+			if len(msgs) > 0 && pithy_print.CanPrint() {
+				SrsTrace(r, r, "-> forward %v, dest=%v, queue=%v, time=%v",
+					pithy_print, r.destination, consumer.Len(), msgs[len(msgs) - 1].Header.Timestamp)
+			}
+		}
+	}
+	return
+}
+
+/**
+* the app and tcUrl to connect to the upstream server, the vhost and the
+* client id are in the query of app, for the upstream to resolve the vhost
+* and log the client id of downstream, for example:
+*		app=live?vhost=demo.srs.com&srs_cid=srs1-ka3j2x1c-100
+*/
+func srs_rtmp_upstream_app(ep string, req *rtmp.Request, id SrsLogId) (app string, tc_url string) {
+	app = fmt.Sprintf("%v?vhost=%v&%v=%v", req.App, req.Vhost, SRS_UPSTREAM_CID, url.QueryEscape(string(id)))
+	tc_url = fmt.Sprintf("rtmp://%v/%v", ep, app)
+	return
+}
+/**
+* connect to the rtmp server in the timeout, the conn is set under the lock,
+* for the owner to close it to interrupt the io.
+* @param stop the chan closed when stopped, never connect when stopped.
+* @return the client and its conn, for the owner to set the deadline.
+*/
+func srs_rtmp_connect(ep string, timeout time.Duration, lock *sync.Mutex, conn **net.TCPConn, stop chan bool) (client rtmp.Client, c *net.TCPConn, err error) {
+	var nc net.Conn
+	if nc, err = net.DialTimeout("tcp4", ep, timeout); err != nil {
+		return
+	}
+	c = nc.(*net.TCPConn)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	select {
+	case <- stop:
+		c.Close()
+		return nil, nil, SrsError{code:ERROR_FORWARDER_CLOSED, desc:"stopped when connecting"}
+	default:
+	}
+
+	if client, err = rtmp.NewClient(c); err != nil {
+		c.Close()
+		return
+	}
+	*conn = c
+	return
+}
@@ -0,0 +1,580 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+// the pid of ts, @see: 2.4.3.3 Semantic definition of fields in Transport Stream packet layer of ts spec.
+const SRS_TS_PID_PAT = 0x0000
+const SRS_TS_PID_PMT = 0x1001
+const SRS_TS_PID_VIDEO = 0x0100
+const SRS_TS_PID_AUDIO = 0x0101
+// the stream type in pmt, @see: 2.4.4.9 Semantic definition of fields in Transport Stream program map section.
+const SRS_TS_STREAM_H264 = 0x1b
+const SRS_TS_STREAM_AAC = 0x0f
+// the stream id of pes, @see: 2.4.3.7 Semantic definition of fields in PES packet.
+const SRS_TS_PES_VIDEO = 0xe0
+const SRS_TS_PES_AUDIO = 0xc0
+// the size of ts packet.
+const SRS_TS_PACKET_SIZE = 188
+// the ext of ts and m3u8 in writing, renamed when done,
+// the http server never serves it.
+const SRS_HLS_TMP_EXT = ".tmp"
+
+/**
+* the crc32 of mpeg-2 for the PSI of ts, poly 0x04C11DB7 without reflect.
+*/
+var srs_ts_crc32_table []uint32 = srs_ts_crc32_init()
+func srs_ts_crc32_init() (table []uint32) {
+	table = make([]uint32, 256)
+	for i := 0; i < 256; i++ {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc & 0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04c11db7
+			} else {
+				crc = crc << 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}
+func srs_ts_crc32(data []byte) (uint32) {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc = (crc << 8) ^ srs_ts_crc32_table[byte(crc >> 24) ^ b]
+	}
+	return crc
+}
+
+/**
+* the ts muxer, mux the h.264 annexb and aac adts to ts file.
+*/
+type SrsTSMuxer struct {
+	f *os.File
+	// the continuity counter of each pid.
+	cc map[uint16]byte
+	// the pid which carries the pcr, video pid, or audio pid for pure audio.
+	pcr_pid uint16
+}
+func NewSrsTSMuxer(f *os.File, has_video bool) (*SrsTSMuxer) {
+	r := &SrsTSMuxer{}
+	r.f = f
+	r.cc = map[uint16]byte{}
+	r.pcr_pid = SRS_TS_PID_AUDIO
+	if has_video {
+		r.pcr_pid = SRS_TS_PID_VIDEO
+	}
+	return r
+}
+/**
+* write the PAT and PMT, at the start of each ts file.
+*/
+func (r *SrsTSMuxer) WritePatPmt() (err error) {
+	// the program 1 use the pmt pid.
+	pat := []byte{ 0x00, 0x01, 0xe0 | byte(SRS_TS_PID_PMT >> 8), byte(SRS_TS_PID_PMT & 0xff) }
+	if err = r.write_psi(SRS_TS_PID_PAT, 0x00, pat); err != nil {
+		return
+	}
+
+	// the pcr pid, no program info, then the video and audio streams.
+	pmt := []byte{ 0xe0 | byte(r.pcr_pid >> 8), byte(r.pcr_pid & 0xff), 0xf0, 0x00 }
+	pmt = append(pmt, SRS_TS_STREAM_H264, 0xe0 | byte(SRS_TS_PID_VIDEO >> 8), byte(SRS_TS_PID_VIDEO & 0xff), 0xf0, 0x00)
+	pmt = append(pmt, SRS_TS_STREAM_AAC, 0xe0 | byte(SRS_TS_PID_AUDIO >> 8), byte(SRS_TS_PID_AUDIO & 0xff), 0xf0, 0x00)
+	return r.write_psi(SRS_TS_PID_PMT, 0x02, pmt)
+}
+/**
+* write the psi section, the PAT or PMT, in a ts packet.
+* @param table_id 0x00 for PAT, 0x02 for PMT.
+* @param data the section data after the last_section_number.
+*/
+func (r *SrsTSMuxer) write_psi(pid uint16, table_id byte, data []byte) (err error) {
+	// the section length includes the 5bytes header after it and the 4bytes crc32.
+	section_length := 5 + len(data) + 4
+	// the program number for PMT, transport stream id for PAT, both 1.
+	section := []byte{ table_id, 0xb0 | byte(section_length >> 8), byte(section_length & 0xff), 0x00, 0x01, 0xc1, 0x00, 0x00 }
+	section = append(section, data...)
+
+	crc := srs_ts_crc32(section)
+	section = append(section, byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc))
+
+	pkt := bytes.Repeat([]byte{ 0xff }, SRS_TS_PACKET_SIZE)
+	pkt[0] = 0x47
+	pkt[1] = 0x40 | byte(pid >> 8) & 0x1f
+	pkt[2] = byte(pid & 0xff)
+	pkt[3] = 0x10 | r.next_cc(pid)
+	// the pointer field.
+	pkt[4] = 0x00
+	copy(pkt[5:], section)
+
+	_, err = r.f.Write(pkt)
+	return
+}
+/**
+* write a video frame in annexb.
+* @param dts and pts in 90khz.
+*/
+func (r *SrsTSMuxer) WriteVideo(dts uint64, pts uint64, data []byte) (err error) {
+	return r.write_pes(SRS_TS_PID_VIDEO, SRS_TS_PES_VIDEO, dts, pts, data)
+}
+/**
+* write an audio frame in adts.
+* @param dts in 90khz.
+*/
+func (r *SrsTSMuxer) WriteAudio(dts uint64, data []byte) (err error) {
+	return r.write_pes(SRS_TS_PID_AUDIO, SRS_TS_PES_AUDIO, dts, dts, data)
+}
+func (r *SrsTSMuxer) write_pes(pid uint16, stream_id byte, dts uint64, pts uint64, data []byte) (err error) {
+	// the pes header, with pts, and dts when not equals to pts.
+	header := []byte{ 0x00, 0x00, 0x01, stream_id, 0x00, 0x00, 0x80 }
+	if dts == pts {
+		header = append(header, 0x80, 5)
+		header = append(header, srs_ts_timestamp(0x02, pts)...)
+	} else {
+		header = append(header, 0xc0, 10)
+		header = append(header, srs_ts_timestamp(0x03, pts)...)
+		header = append(header, srs_ts_timestamp(0x01, dts)...)
+	}
+
+	// the pes packet length, 0 for unbounded video.
+	if pes_length := len(header) - 6 + len(data); pes_length <= 0xffff && stream_id != SRS_TS_PES_VIDEO {
+		header[4], header[5] = byte(pes_length >> 8), byte(pes_length & 0xff)
+	}
+
+	payload := append(header, data...)
+	for first := true; len(payload) > 0; first = false {
+		pkt := make([]byte, SRS_TS_PACKET_SIZE)
+		pkt[0] = 0x47
+		pkt[1] = byte(pid >> 8) & 0x1f
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid & 0xff)
+
+		// the adaptation field, the length and data.
+		var af []byte
+		if first && pid == r.pcr_pid {
+			af = append([]byte{ 7, 0x10 }, srs_ts_pcr(dts)...)
+		}
+
+		// stuffing the last packet by adaptation field.
+		space := SRS_TS_PACKET_SIZE - 4 - len(af)
+		if len(payload) < space {
+			stuffing := space - len(payload)
+			if af == nil {
+				af = []byte{ byte(stuffing - 1) }
+				if stuffing > 1 {
+					af = append(af, 0x00)
+					af = append(af, bytes.Repeat([]byte{ 0xff }, stuffing - 2)...)
+				}
+			} else {
+				af[0] += byte(stuffing)
+				af = append(af, bytes.Repeat([]byte{ 0xff }, stuffing)...)
+			}
+			space = len(payload)
+		}
+
+		pkt[3] = 0x10 | r.next_cc(pid)
+		if af != nil {
+			pkt[3] |= 0x20
+		}
+		copy(pkt[4:], af)
+		copy(pkt[4 + len(af):], payload[:space])
+		payload = payload[space:]
+
+		if _, err = r.f.Write(pkt); err != nil {
+			return
+		}
+	}
+	return
+}
+func (r *SrsTSMuxer) next_cc(pid uint16) (cc byte) {
+	cc = r.cc[pid]
+	r.cc[pid] = (cc + 1) & 0x0f
+	return
+}
+/**
+* encode the 33bits pts or dts, @see: 2.4.3.7 Semantic definition of fields in PES packet.
+*/
+func srs_ts_timestamp(flag byte, ts uint64) ([]byte) {
+	return []byte{
+		(flag << 4) | byte((ts >> 29) & 0x0e) | 0x01,
+		byte(ts >> 22),
+		byte((ts >> 14) & 0xfe) | 0x01,
+		byte(ts >> 7),
+		byte((ts << 1) & 0xfe) | 0x01,
+	}
+}
+/**
+* encode the pcr base, the extension is 0, @see: 2.4.3.5 Semantic definition of fields in adaptation field.
+*/
+func srs_ts_pcr(pcr uint64) ([]byte) {
+	return []byte{
+		byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1),
+		byte((pcr & 0x01) << 7) | 0x7e, 0x00,
+	}
+}
+
+/**
+* the ts segment in m3u8.
+*/
+type SrsHlsSegment struct {
+	// the sequence number of segment.
+	seq int
+	// the uri in m3u8, the file name, for example, livestream-0.ts
+	uri string
+	// the full path of ts file.
+	path string
+	// the dts in ms of the first message.
+	start_dts uint64
+	// the duration in seconds.
+	duration float64
+}
+
+/**
+* the hls for source, mux the video and audio to ts segments,
+* and maintain the sliding window m3u8, where:
+*		[path]/[vhost]/[app]/[stream].m3u8
+*		[path]/[vhost]/[app]/[stream]-[seq].ts
+*/
+type SrsHls struct {
+	source *SrsSource
+	conf *SrsConfHls
+	codec *SrsCodec
+	// whether hls is publishing, false when hls disabled or error.
+	publishing bool
+	// the dir and stream name of m3u8 and ts.
+	dir string
+	stream string
+	// the sequence number of the next segment.
+	seq int
+	// the segments in m3u8.
+	segments []*SrsHlsSegment
+	// the current segment and its ts muxer.
+	current *SrsHlsSegment
+	muxer *SrsTSMuxer
+	f *os.File
+}
+func NewSrsHls(source *SrsSource) (*SrsHls) {
+	r := &SrsHls{}
+	r.source = source
+	r.conf = source.vhost.hls
+	r.codec = NewSrsCodec()
+	return r
+}
+
+// interface for Log
+func (r *SrsHls) GetId() (SrsLogId) {
+	return r.source.GetId()
+}
+func (r *SrsHls) GetTag() (SrsLogTag) {
+	return "hls"
+}
+func (r *SrsHls) GetLogVhost() (*SrsConfVhost) {
+	return r.source.vhost
+}
+
+/**
+* when publish stream, prepare the dir of hls.
+*/
+func (r *SrsHls) OnPublish(req *rtmp.Request) {
+	if !r.conf.enabled {
+		return
+	}
+
+	// the vhost in path, the streams of vhosts never overwrite each other.
+	r.dir = filepath.Join(r.conf.path, req.Vhost, req.App)
+	r.stream = req.Stream
+	if srs_check_name("app", req.App) != nil || srs_check_name("stream", req.Stream) != nil || !srs_hls_path_under(r.conf.path, r.dir) {
+		SrsWarn(r, r, "hls disabled for invalid path, vhost=%v, app=%q, stream=%q", req.Vhost, req.App, req.Stream)
+		return
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		SrsWarn(r, r, "hls disabled for create dir %v failed, err=%v", r.dir, err)
+		return
+	}
+
+	// the segments of last publish are removed, the codec maybe changed.
+	for _, v := range r.segments {
+		os.Remove(v.path)
+	}
+	r.segments = nil
+	r.codec = NewSrsCodec()
+	r.publishing = true
+
+	SrsTrace(r, r, "hls publish, m3u8=%v, fragment=%vms, window=%vms", r.m3u8_path(), r.conf.fragment_ms, r.conf.window_ms)
+	return
+}
+/**
+* when unpublish stream, reap the current segment and end the m3u8.
+*/
+func (r *SrsHls) OnUnpublish() {
+	if !r.publishing {
+		return
+	}
+	r.publishing = false
+
+	if err := r.reap_segment(); err != nil {
+		r.on_error(err)
+		return
+	}
+	if err := r.write_m3u8(true); err != nil {
+		r.on_error(err)
+		return
+	}
+	SrsTrace(r, r, "hls unpublish, segments=%v", len(r.segments))
+}
+func (r *SrsHls) OnAudio(msg *rtmp.Message) {
+	if !r.publishing || !srs_codec_audio_is_aac(msg.Payload) {
+		return
+	}
+
+	if srs_codec_audio_is_sequence_header(msg.Payload) {
+		if err := r.codec.DecodeAudioSequenceHeader(msg.Payload); err != nil {
+			r.on_error(err)
+		}
+		return
+	}
+
+	frame, err := r.codec.DemuxAudioAdts(msg.Payload)
+	if err != nil {
+		r.on_error(err)
+		return
+	}
+
+	// for pure audio, reap the segment by audio.
+	if !r.codec.HasVideo() {
+		if err = r.reap_if_required(msg.Header.Timestamp); err != nil {
+			r.on_error(err)
+			return
+		}
+	}
+
+	// wait for the first keyframe when has video.
+	if r.current == nil {
+		return
+	}
+	r.update_duration(msg.Header.Timestamp)
+
+	if err = r.muxer.WriteAudio(msg.Header.Timestamp * 90, frame); err != nil {
+		r.on_error(err)
+	}
+}
+func (r *SrsHls) OnVideo(msg *rtmp.Message) {
+	if !r.publishing || !srs_codec_video_is_h264(msg.Payload) {
+		return
+	}
+
+	if srs_codec_video_is_sequence_header(msg.Payload) {
+		if err := r.codec.DecodeVideoSequenceHeader(msg.Payload); err != nil {
+			r.on_error(err)
+		}
+		return
+	}
+
+	cts, nalus, err := r.codec.DemuxVideo(msg.Payload)
+	if err != nil {
+		r.on_error(err)
+		return
+	}
+
+	// the segment always starts with keyframe.
+	keyframe := srs_codec_video_is_keyframe(msg.Payload)
+	if keyframe {
+		if err = r.reap_if_required(msg.Header.Timestamp); err != nil {
+			r.on_error(err)
+			return
+		}
+	}
+	if r.current == nil {
+		return
+	}
+	r.update_duration(msg.Header.Timestamp)
+
+	// the annexb with AUD, and sps/pps before keyframe.
+	annexb := []byte{ 0x00, 0x00, 0x00, 0x01, SRS_AVC_NALU_AUD, 0xf0 }
+	if keyframe {
+		annexb = append(annexb, 0x00, 0x00, 0x00, 0x01)
+		annexb = append(annexb, r.codec.sps...)
+		annexb = append(annexb, 0x00, 0x00, 0x00, 0x01)
+		annexb = append(annexb, r.codec.pps...)
+	}
+	for _, nalu := range nalus {
+		if len(nalu) == 0 || nalu[0] & 0x1f == SRS_AVC_NALU_AUD {
+			continue
+		}
+		annexb = append(annexb, 0x00, 0x00, 0x00, 0x01)
+		annexb = append(annexb, nalu...)
+	}
+
+	dts := msg.Header.Timestamp * 90
+	pts := uint64(int64(dts) + int64(cts) * 90)
+	if err = r.muxer.WriteVideo(dts, pts, annexb); err != nil {
+		r.on_error(err)
+	}
+}
+/**
+* when error, disable the hls of this publish, never affect the publisher.
+*/
+func (r *SrsHls) on_error(err error) {
+	SrsWarn(r, r, "hls error, disable hls until next publish, err=%v", err)
+	r.publishing = false
+	r.close_segment()
+}
+func (r *SrsHls) update_duration(dts uint64) {
+	if dts > r.current.start_dts {
+		r.current.duration = float64(dts - r.current.start_dts) / 1000
+	}
+}
+/**
+* reap the current segment when exceed the fragment, and open a new segment.
+*/
+func (r *SrsHls) reap_if_required(dts uint64) (err error) {
+	if r.current != nil {
+		r.update_duration(dts)
+		if r.current.duration * 1000 < float64(r.conf.fragment_ms) {
+			return
+		}
+		if err = r.reap_segment(); err != nil {
+			return
+		}
+		if err = r.write_m3u8(false); err != nil {
+			return
+		}
+	}
+	return r.open_segment(dts)
+}
+func (r *SrsHls) open_segment(dts uint64) (err error) {
+	r.current = &SrsHlsSegment{seq:r.seq, start_dts:dts}
+	r.current.uri = fmt.Sprintf("%v-%v.ts", r.stream, r.seq)
+	r.current.path = filepath.Join(r.dir, r.current.uri)
+	r.seq++
+
+	// write to the tmp file, rename when reap.
+	if r.f, err = os.Create(r.current.path + SRS_HLS_TMP_EXT); err != nil {
+		r.current = nil
+		return SrsError{code:ERROR_HLS_WRITE_FAILED, desc:fmt.Sprintf("create ts failed, err=%v", err)}
+	}
+
+	r.muxer = NewSrsTSMuxer(r.f, r.codec.HasVideo())
+	if err = r.muxer.WritePatPmt(); err != nil {
+		return SrsError{code:ERROR_HLS_WRITE_FAILED, desc:fmt.Sprintf("write pat/pmt failed, err=%v", err)}
+	}
+	SrsVerbose(r, r, "open segment %v", r.current.path)
+	return
+}
+func (r *SrsHls) close_segment() {
+	if r.f != nil {
+		r.f.Close()
+		os.Remove(r.current.path + SRS_HLS_TMP_EXT)
+	}
+	r.f, r.muxer, r.current = nil, nil, nil
+}
+/**
+* close the current segment, append to m3u8, and remove the segments out of window.
+*/
+func (r *SrsHls) reap_segment() (err error) {
+	if r.current == nil {
+		return
+	}
+	segment, f := r.current, r.f
+	r.f, r.muxer, r.current = nil, nil, nil
+
+	if err = f.Close(); err != nil {
+		return SrsError{code:ERROR_HLS_WRITE_FAILED, desc:fmt.Sprintf("close ts failed, err=%v", err)}
+	}
+	if err = os.Rename(segment.path + SRS_HLS_TMP_EXT, segment.path); err != nil {
+		return SrsError{code:ERROR_HLS_WRITE_FAILED, desc:fmt.Sprintf("rename ts failed, err=%v", err)}
+	}
+	r.segments = append(r.segments, segment)
+
+	// shrink the window, keep at least one segment.
+	duration := 0.0
+	for _, v := range r.segments {
+		duration += v.duration
+	}
+	for len(r.segments) > 1 && duration * 1000 > float64(r.conf.window_ms) {
+		duration -= r.segments[0].duration
+		if err := os.Remove(r.segments[0].path); err != nil {
+			SrsWarn(r, r, "ignore the remove ts err=%v", err)
+		}
+		r.segments = r.segments[1:]
+	}
+
+	SrsTrace(r, r, "reap segment %v, duration=%.2fs, segments=%v", segment.uri, segment.duration, len(r.segments))
+	return
+}
+/**
+* whether the path is under the dir, to never write out of the dir of hls.
+*/
+func srs_hls_path_under(dir string, path string) (bool) {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".." + string(filepath.Separator))
+}
+func (r *SrsHls) m3u8_path() (string) {
+	return filepath.Join(r.dir, r.stream + ".m3u8")
+}
+/**
+* write the m3u8 of segments, write to tmp file then rename.
+* @param end whether stream is end, write the EXT-X-ENDLIST.
+*/
+func (r *SrsHls) write_m3u8(end bool) (err error) {
+	if len(r.segments) == 0 {
+		return
+	}
+
+	target := 0.0
+	for _, v := range r.segments {
+		target = math.Max(target, v.duration)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString(fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%v\n", r.segments[0].seq))
+	b.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%v\n", int(math.Ceil(target))))
+	for _, v := range r.segments {
+		b.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%v\n", v.duration, v.uri))
+	}
+	if end {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	tmp := r.m3u8_path() + SRS_HLS_TMP_EXT
+	if err = ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return SrsError{code:ERROR_HLS_WRITE_FAILED, desc:fmt.Sprintf("write m3u8 failed, err=%v", err)}
+	}
+	if err = os.Rename(tmp, r.m3u8_path()); err != nil {
+		return SrsError{code:ERROR_HLS_WRITE_FAILED, desc:fmt.Sprintf("rename m3u8 failed, err=%v", err)}
+	}
+	return
+}
@@ -0,0 +1,321 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+func TestTsCrc32(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		crc uint32
+	}{
+		// the check value of CRC-32/MPEG-2.
+		{"check", []byte("123456789"), 0x0376e6e7},
+		// the PAT of ffmpeg, the program 1 with pmt pid 0x1000.
+		{"ffmpeg pat", []byte{ 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00 }, 0x2ab104b2},
+	}
+
+	for _, c := range cases {
+		if crc := srs_ts_crc32(c.data); crc != c.crc {
+			t.Errorf("%v: crc %#x, expect %#x", c.name, crc, c.crc)
+		}
+	}
+}
+
+func TestTsPatPmt(t *testing.T) {
+	cases := []struct {
+		name string
+		has_video bool
+		// the header of ts packet, and the section without crc32.
+		pat []byte
+		pmt []byte
+	}{
+		{"video", true,
+			[]byte{ 0x47, 0x40, 0x00, 0x10, 0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x01 },
+			[]byte{ 0x47, 0x50, 0x01, 0x10, 0x00, 0x02, 0xb0, 0x17, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x00, 0xf0, 0x00,
+				0x1b, 0xe1, 0x00, 0xf0, 0x00, 0x0f, 0xe1, 0x01, 0xf0, 0x00 }},
+		{"pure audio", false,
+			[]byte{ 0x47, 0x40, 0x00, 0x10, 0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x01 },
+			[]byte{ 0x47, 0x50, 0x01, 0x10, 0x00, 0x02, 0xb0, 0x17, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x01, 0xf0, 0x00,
+				0x1b, 0xe1, 0x00, 0xf0, 0x00, 0x0f, 0xe1, 0x01, 0xf0, 0x00 }},
+	}
+
+	for _, c := range cases {
+		data := srs_test_ts_mux(t, c.has_video, func(muxer *SrsTSMuxer) (error) {
+			return muxer.WritePatPmt()
+		})
+		if len(data) != 2 * SRS_TS_PACKET_SIZE {
+			t.Fatalf("%v: size %v, expect 2 packets", c.name, len(data))
+		}
+
+		for i, expect := range [][]byte{ c.pat, c.pmt } {
+			pkt := data[i * SRS_TS_PACKET_SIZE:(i + 1) * SRS_TS_PACKET_SIZE]
+			if !bytes.HasPrefix(pkt, expect) {
+				t.Errorf("%v: packet %v is %x, expect %x", c.name, i, pkt[:len(expect)], expect)
+				continue
+			}
+
+			// the crc32 of mpeg-2 over the section and its crc32 is 0.
+			section := pkt[5:len(expect) + 4]
+			if crc := srs_ts_crc32(section); crc != 0 {
+				t.Errorf("%v: packet %v crc32 invalid, %x", c.name, i, section)
+			}
+			if !bytes.Equal(pkt[len(expect) + 4:], bytes.Repeat([]byte{ 0xff }, SRS_TS_PACKET_SIZE - len(expect) - 4)) {
+				t.Errorf("%v: packet %v stuffing invalid", c.name, i)
+			}
+		}
+	}
+}
+
+func TestTsTimestamp(t *testing.T) {
+	cases := []struct {
+		flag byte
+		ts uint64
+		expect []byte
+	}{
+		{0x02, 0, []byte{ 0x21, 0x00, 0x01, 0x00, 0x01 }},
+		{0x02, 126000, []byte{ 0x21, 0x00, 0x07, 0xd8, 0x61 }},
+		{0x02, 1 << 32, []byte{ 0x29, 0x00, 0x01, 0x00, 0x01 }},
+		{0x03, 0x1ffffffff, []byte{ 0x3f, 0xff, 0xff, 0xff, 0xff }},
+		{0x01, 0x1ffffffff, []byte{ 0x1f, 0xff, 0xff, 0xff, 0xff }},
+	}
+	for _, c := range cases {
+		if v := srs_ts_timestamp(c.flag, c.ts); !bytes.Equal(v, c.expect) {
+			t.Errorf("timestamp %v flag %v is %x, expect %x", c.ts, c.flag, v, c.expect)
+		}
+	}
+
+	pcrs := []struct {
+		pcr uint64
+		expect []byte
+	}{
+		{0, []byte{ 0x00, 0x00, 0x00, 0x00, 0x7e, 0x00 }},
+		{1, []byte{ 0x00, 0x00, 0x00, 0x00, 0xfe, 0x00 }},
+		{126000, []byte{ 0x00, 0x00, 0xf6, 0x18, 0x7e, 0x00 }},
+		{0x1ffffffff, []byte{ 0xff, 0xff, 0xff, 0xff, 0xfe, 0x00 }},
+	}
+	for _, c := range pcrs {
+		if v := srs_ts_pcr(c.pcr); !bytes.Equal(v, c.expect) {
+			t.Errorf("pcr %v is %x, expect %x", c.pcr, v, c.expect)
+		}
+	}
+}
+
+func TestTsPes(t *testing.T) {
+	// the audio pes with pts only, stuffing in the adaptation field with pcr.
+	data := srs_test_ts_mux(t, false, func(muxer *SrsTSMuxer) (error) {
+		return muxer.WriteAudio(126000, []byte{ 0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x10 })
+	})
+	if len(data) != SRS_TS_PACKET_SIZE {
+		t.Fatalf("audio size %v, expect 1 packet", len(data))
+	}
+
+	// the payload start, pid 0x101, af with payload, af length 183-8-14-9.
+	af_length := byte(SRS_TS_PACKET_SIZE - 4 - 1 - 14 - 9)
+	expect := []byte{ 0x47, 0x41, 0x01, 0x30, af_length, 0x10, 0x00, 0x00, 0xf6, 0x18, 0x7e, 0x00 }
+	if !bytes.HasPrefix(data, expect) {
+		t.Errorf("audio ts header %x, expect %x", data[:len(expect)], expect)
+	}
+
+	pes := []byte{ 0x00, 0x00, 0x01, 0xc0, 0x00, 0x11, 0x80, 0x80, 0x05, 0x21, 0x00, 0x07, 0xd8, 0x61,
+		0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x10 }
+	if !bytes.HasSuffix(data, pes) {
+		t.Errorf("audio pes %x, expect %x", data[len(data) - len(pes):], pes)
+	}
+
+	// the video pes with pts and dts, unbounded length, in 2 packets.
+	frame := bytes.Repeat([]byte{ 0x01 }, 200)
+	data = srs_test_ts_mux(t, true, func(muxer *SrsTSMuxer) (error) {
+		return muxer.WriteVideo(0, 3600, frame)
+	})
+	if len(data) != 2 * SRS_TS_PACKET_SIZE {
+		t.Fatalf("video size %v, expect 2 packets", len(data))
+	}
+	pes = []byte{ 0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0xc0, 0x0a,
+		0x31, 0x00, 0x01, 0x1c, 0x21, 0x11, 0x00, 0x01, 0x00, 0x01 }
+	if !bytes.Equal(data[12:12 + len(pes)], pes) {
+		t.Errorf("video pes %x, expect %x", data[12:12 + len(pes)], pes)
+	}
+	// the second packet continues the payload, the cc increased.
+	if data[SRS_TS_PACKET_SIZE + 1] != 0x01 || data[SRS_TS_PACKET_SIZE + 3] & 0x0f != 1 {
+		t.Errorf("video second packet header %x invalid", data[SRS_TS_PACKET_SIZE:SRS_TS_PACKET_SIZE + 4])
+	}
+}
+
+func TestAacAdts(t *testing.T) {
+	codec := NewSrsCodec()
+	// the AAC-LC, 44100Hz, stereo.
+	if err := codec.DecodeAudioSequenceHeader([]byte{ 0xaf, 0x00, 0x12, 0x10 }); err != nil {
+		t.Fatalf("decode aac sequence header failed, err=%v", err)
+	}
+
+	frame, err := codec.DemuxAudioAdts([]byte{ 0xaf, 0x01, 0x21, 0x10 })
+	if err != nil {
+		t.Fatalf("demux aac failed, err=%v", err)
+	}
+	expect := []byte{ 0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x10 }
+	if !bytes.Equal(frame, expect) {
+		t.Errorf("adts %x, expect %x", frame, expect)
+	}
+}
+
+func TestHlsWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srs-hls")
+	if err != nil {
+		t.Fatalf("create dir failed, err=%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// publish the same app/stream in two vhosts.
+	hlss := []*SrsHls{}
+	for _, name := range []string{ "a.srs.com", "b.srs.com" } {
+		vhost := NewSrsConfVhost(name)
+		vhost.hls.enabled = true
+		vhost.hls.path = dir
+		vhost.hls.fragment_ms = 10000
+		vhost.hls.window_ms = 30000
+
+		hls := NewSrsHls(&SrsSource{id:SrsLogId(name), vhost:vhost})
+		hls.OnPublish(&rtmp.Request{Vhost:name, App:"live", Stream:"livestream"})
+		hls.OnAudio(&rtmp.Message{Header:&rtmp.MessageHeader{MessageType:8}, Payload:[]byte{ 0xaf, 0x00, 0x12, 0x10 }})
+		hlss = append(hlss, hls)
+	}
+
+	// the pure audio in 60s, reap each 10s.
+	for ts := uint64(0); ts <= 60000; ts += 100 {
+		for _, hls := range hlss {
+			hls.OnAudio(&rtmp.Message{Header:&rtmp.MessageHeader{MessageType:8, Timestamp:ts}, Payload:[]byte{ 0xaf, 0x01, 0x21, 0x10 }})
+		}
+	}
+
+	for _, name := range []string{ "a.srs.com", "b.srs.com" } {
+		m3u8, err := ioutil.ReadFile(filepath.Join(dir, name, "live", "livestream.m3u8"))
+		if err != nil {
+			t.Fatalf("%v: read m3u8 failed, err=%v", name, err)
+		}
+
+		// the segments 0-5 are reaped, the window keeps 3 segments of 30s.
+		for _, line := range []string{ "#EXT-X-MEDIA-SEQUENCE:3\n", "#EXT-X-TARGETDURATION:10\n",
+			"#EXTINF:10.000,\nlivestream-3.ts\n", "#EXTINF:10.000,\nlivestream-5.ts\n" } {
+			if !strings.Contains(string(m3u8), line) {
+				t.Errorf("%v: m3u8 without %q\n%v", name, line, string(m3u8))
+			}
+		}
+		if strings.Contains(string(m3u8), "livestream-2.ts") {
+			t.Errorf("%v: m3u8 should not contains the segment out of window\n%v", name, string(m3u8))
+		}
+
+		// the segments out of window are deleted.
+		for seq, exists := range []bool{ false, false, false, true, true, true } {
+			_, err := os.Stat(filepath.Join(dir, name, "live", fmt.Sprintf("livestream-%v.ts", seq)))
+			if exists != (err == nil) {
+				t.Errorf("%v: segment %v exists=%v, err=%v", name, seq, exists, err)
+			}
+		}
+	}
+}
+
+func TestHlsInvalidName(t *testing.T) {
+	root, err := ioutil.TempDir("", "srs-hls")
+	if err != nil {
+		t.Fatalf("create dir failed, err=%v", err)
+	}
+	defer os.RemoveAll(root)
+
+	// the hls dir in the root, the publisher never write out of it.
+	dir := filepath.Join(root, "hls")
+	cases := []struct {
+		app string
+		stream string
+	}{
+		{"../../etc", "livestream"},
+		{"..", "livestream"},
+		{"live/..", "livestream"},
+		{"live", "../../livestream"},
+		{"live", "a/b"},
+		{"live", "a\\b"},
+		{"live", "a\x00b"},
+		{"live\x00", "livestream"},
+	}
+
+	for _, c := range cases {
+		if srs_check_name("app", c.app) == nil && srs_check_name("stream", c.stream) == nil {
+			t.Errorf("app=%q, stream=%q should be invalid", c.app, c.stream)
+		}
+
+		vhost := NewSrsConfVhost("a.srs.com")
+		vhost.hls.enabled = true
+		vhost.hls.path = dir
+
+		hls := NewSrsHls(&SrsSource{id:SrsLogId("a.srs.com"), vhost:vhost})
+		hls.OnPublish(&rtmp.Request{Vhost:"a.srs.com", App:c.app, Stream:c.stream})
+		if hls.publishing {
+			t.Errorf("app=%q, stream=%q should disable hls", c.app, c.stream)
+		}
+	}
+
+	// nothing created, even the dir of hls.
+	if files, _ := ioutil.ReadDir(root); len(files) != 0 {
+		t.Errorf("invalid name should never create file, got %v", files[0].Name())
+	}
+
+	for _, name := range []string{ "live", "livestream", "live.sd", "a_b-c" } {
+		if err := srs_check_name("stream", name); err != nil {
+			t.Errorf("stream %q should be valid, err=%v", name, err)
+		}
+	}
+	if srs_hls_path_under(dir, filepath.Join(dir, "..", "etc")) || !srs_hls_path_under(dir, filepath.Join(dir, "a.srs.com", "live")) {
+		t.Errorf("check path under %v failed", dir)
+	}
+}
+
+/**
+* mux by the ts muxer, return the ts data.
+*/
+func srs_test_ts_mux(t *testing.T, has_video bool, mux func(muxer *SrsTSMuxer) (error)) ([]byte) {
+	f, err := ioutil.TempFile("", "srs-ts")
+	if err != nil {
+		t.Fatalf("create ts failed, err=%v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err = mux(NewSrsTSMuxer(f, has_video)); err != nil {
+		t.Fatalf("mux ts failed, err=%v", err)
+	}
+
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("read ts failed, err=%v", err)
+	}
+	return data
+}
@@ -0,0 +1,382 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"github.com/winlinvip/go.rtmp/rtmp"
+)
+
+// the code of http api response, 0 for success.
+const SRS_API_CODE_SUCCESS = 0
+const SRS_API_CODE_UNAUTHORIZED = 401
+const SRS_API_CODE_FORBIDDEN = 403
+const SRS_API_CODE_NOT_FOUND = 404
+
+// the time when server start, for the uptime of server.
+var srs_server_start_time time.Time = time.Now()
+
+/**
+* the summary of server, @see: /api/v1/summaries
+*/
+type SrsApiSummary struct {
+	Node string `json:"node"`
+	Version string `json:"version"`
+	Pid int `json:"pid"`
+	UptimeMs int64 `json:"uptime_ms"`
+	Streams int `json:"streams"`
+	Publishers int `json:"publishers"`
+	Clients int `json:"clients"`
+	Players int `json:"players"`
+	SendBytes uint64 `json:"send_bytes"`
+	RecvBytes uint64 `json:"recv_bytes"`
+	SendKbps int `json:"send_kbps"`
+	RecvKbps int `json:"recv_kbps"`
+}
+/**
+* the vhost and its streams and clients, @see: /api/v1/vhosts
+*/
+type SrsApiVhost struct {
+	Name string `json:"name"`
+	Enabled bool `json:"enabled"`
+	Mode string `json:"mode"`
+	Streams int `json:"streams"`
+	Clients int `json:"clients"`
+	SendKbps int `json:"send_kbps"`
+	RecvKbps int `json:"recv_kbps"`
+}
+/**
+* the stream, the publisher, codec and kbps, @see: /api/v1/streams
+*/
+type SrsApiStream struct {
+	Id SrsLogId `json:"id"`
+	Url string `json:"url"`
+	Vhost string `json:"vhost"`
+	App string `json:"app"`
+	Stream string `json:"stream"`
+	// the publisher, nil when not publishing.
+	Publisher *SrsApiPublisher `json:"publisher"`
+	// the edge ingest, nil for origin.
+	Edge *SrsApiEdge `json:"edge,omitempty"`
+	// the consumers of stream, that is the players.
+	Clients int `json:"clients"`
+	// the messages dropped by the consumers when queue overflow.
+	Dropped uint64 `json:"dropped"`
+	// the bytes and kbps of stream received from publisher or origin.
+	RecvBytes uint64 `json:"recv_bytes"`
+	RecvKbps int `json:"recv_kbps"`
+	// the codec from sequence headers, nil when no such codec.
+	Video *SrsApiVideo `json:"video"`
+	Audio *SrsApiAudio `json:"audio"`
+	Forwarders []*SrsApiForwarder `json:"forwarders"`
+}
+type SrsApiPublisher struct {
+	Id SrsLogId `json:"id"`
+	Ip string `json:"ip"`
+}
+type SrsApiEdge struct {
+	State string `json:"state"`
+	Origin string `json:"origin"`
+}
+type SrsApiVideo struct {
+	Codec string `json:"codec"`
+	Profile int `json:"profile"`
+	Level int `json:"level"`
+}
+type SrsApiAudio struct {
+	Codec string `json:"codec"`
+	Object int `json:"object"`
+	SampleRate int `json:"sample_rate"`
+	Channels int `json:"channels"`
+}
+type SrsApiForwarder struct {
+	Destination string `json:"destination"`
+	State string `json:"state"`
+	SendBytes uint64 `json:"send_bytes"`
+	Error string `json:"error,omitempty"`
+}
+/**
+* the client, the type, url, age and bytes, @see: /api/v1/clients
+*/
+type SrsApiClient struct {
+	Id SrsLogId `json:"id"`
+	Ip string `json:"ip"`
+	// the type of client, empty before identified,
+	// Play, FMLEPublish or FlashPublish.
+	Type string `json:"type"`
+	Vhost string `json:"vhost"`
+	App string `json:"app"`
+	Stream string `json:"stream"`
+	Url string `json:"url"`
+	// the chunk size to send to client, 0 if not set.
+	ChunkSize int `json:"chunk_size"`
+	AgeMs int64 `json:"age_ms"`
+	SendBytes uint64 `json:"send_bytes"`
+	RecvBytes uint64 `json:"recv_bytes"`
+	SendKbps int `json:"send_kbps"`
+	RecvKbps int `json:"recv_kbps"`
+}
+
+/**
+* the http api to query the statistic of server in json, for example:
+*		GET /api/v1/summaries
+*		{"code":0, "summaries":{"streams":1, "clients":2, ...}}
+* and the admin api to kick clients, authenticated by the admin token:
+*		DELETE /api/v1/clients/<id>
+*		{"code":0, "kicked":["<id>"]}
+*/
+type SrsHttpApi struct {
+	id SrsLogId
+	conf *SrsConfHttpApi
+}
+func NewSrsHttpApi(conf *SrsConfHttpApi) (*SrsHttpApi) {
+	r := &SrsHttpApi{}
+	r.id = SrsGenerateId()
+	r.conf = conf
+	return r
+}
+
+// interface for Log
+func (r *SrsHttpApi) GetId() (SrsLogId) {
+	return r.id
+}
+func (r *SrsHttpApi) GetTag() (SrsLogTag) {
+	return "api"
+}
+
+func (r *SrsHttpApi) Serve() {
+	ep := r.conf.listen
+	if !strings.Contains(ep, ":") {
+		ep = ":" + ep
+	}
+
+	SrsTrace(r, r, "http api listen at %v", ep)
+	if err := http.ListenAndServe(ep, r); err != nil {
+		SrsFatal(r, r, "http api listen %v failed, err=%v", ep, err)
+	}
+}
+
+func (r *SrsHttpApi) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// allow the cross domain request for the dashboard.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Accept, Content-Type, Authorization")
+	w.Header().Set("Server", RTMP_SIG_SRS_KEY + "/" + RTMP_SIG_SRS_VERSION)
+
+	switch req.Method {
+	case "OPTIONS":
+		return
+	case "GET":
+	case "DELETE":
+		r.serve_admin(w, req)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch path.Clean("/" + req.URL.Path) {
+	case "/api/v1/summaries":
+		r.response(w, http.StatusOK, map[string]interface{}{ "summaries": srs_api_summaries() })
+	case "/api/v1/vhosts":
+		r.response(w, http.StatusOK, map[string]interface{}{ "vhosts": srs_api_vhosts() })
+	case "/api/v1/streams":
+		r.response(w, http.StatusOK, map[string]interface{}{ "streams": srs_api_streams() })
+	case "/api/v1/clients":
+		r.response(w, http.StatusOK, map[string]interface{}{ "clients": srs_api_clients() })
+	default:
+		r.response(w, http.StatusNotFound, map[string]interface{}{ "code": SRS_API_CODE_NOT_FOUND })
+	}
+	SrsVerbose(r, r, "http api serve %v", req.URL.Path)
+}
+/**
+* the admin api to kick the clients, the client is kicked by interrupt
+* its io, then closed by the normal path, with the http hooks.
+*/
+func (r *SrsHttpApi) serve_admin(w http.ResponseWriter, req *http.Request) {
+	if r.conf.admin_token == "" {
+		r.response(w, http.StatusForbidden, map[string]interface{}{ "code": SRS_API_CODE_FORBIDDEN })
+		return
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.conf.admin_token)) != 1 {
+		SrsWarn(r, r, "http api admin denied, remote=%v, path=%v", req.RemoteAddr, req.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		r.response(w, http.StatusUnauthorized, map[string]interface{}{ "code": SRS_API_CODE_UNAUTHORIZED })
+		return
+	}
+
+	reason := fmt.Sprintf("kicked by admin api from %v", req.RemoteAddr)
+	kicked := []SrsLogId{}
+	// whether the client or stream found, a stream maybe has no player.
+	found := false
+
+	// for example, clients/<id>, streams/<id>/clients or streams/<id>/publisher
+	paths := strings.Split(strings.TrimPrefix(path.Clean("/" + req.URL.Path), "/api/v1/"), "/")
+	switch {
+	case len(paths) == 2 && paths[0] == "clients":
+		if client := FindSrsClient(SrsLogId(paths[1])); client != nil {
+			client.Kick(reason)
+			kicked = append(kicked, client.GetId())
+			found = true
+		}
+	case len(paths) == 3 && paths[0] == "streams" && paths[2] == "clients":
+		source := srs_api_find_source(SrsLogId(paths[1]))
+		if source == nil {
+			break
+		}
+		found = true
+		stream_url := source.req.StreamUrl()
+		for _, client := range ListSrsClients() {
+			if client_type, url := client.Identified(); client_type == rtmp.CLIENT_TYPE_Play && url == stream_url {
+				client.Kick(reason)
+				kicked = append(kicked, client.GetId())
+			}
+		}
+	case len(paths) == 3 && paths[0] == "streams" && paths[2] == "publisher":
+		source := srs_api_find_source(SrsLogId(paths[1]))
+		if source == nil {
+			break
+		}
+		if client := source.Publisher(); client != nil {
+			client.Kick(reason)
+			kicked = append(kicked, client.GetId())
+			found = true
+		}
+	}
+
+	if !found {
+		r.response(w, http.StatusNotFound, map[string]interface{}{ "code": SRS_API_CODE_NOT_FOUND })
+		return
+	}
+	SrsTrace(r, r, "http api admin %v, kicked=%v", req.URL.Path, kicked)
+	r.response(w, http.StatusOK, map[string]interface{}{ "kicked": kicked })
+}
+/**
+* response the json object, the code is success when not specified.
+*/
+func (r *SrsHttpApi) response(w http.ResponseWriter, status int, obj map[string]interface{}) {
+	if _, ok := obj["code"]; !ok {
+		obj["code"] = SRS_API_CODE_SUCCESS
+	}
+	obj["server"] = r.id
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		SrsWarn(r, r, "http api marshal failed, err=%v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func srs_api_find_source(id SrsLogId) (*SrsSource) {
+	for _, source := range ListSrsSources() {
+		if source.id == id {
+			return source
+		}
+	}
+	return nil
+}
+func srs_api_streams() (streams []*SrsApiStream) {
+	streams = []*SrsApiStream{}
+	for _, source := range ListSrsSources() {
+		streams = append(streams, source.Stat())
+	}
+	return
+}
+func srs_api_clients() (clients []*SrsApiClient) {
+	clients = []*SrsApiClient{}
+	for _, client := range ListSrsClients() {
+		clients = append(clients, client.Stat())
+	}
+	return
+}
+func srs_api_summaries() (*SrsApiSummary) {
+	v := &SrsApiSummary{}
+	v.Node = SrsNodeId()
+	v.Version = RTMP_SIG_SRS_VERSION
+	v.Pid = os.Getpid()
+	v.UptimeMs = int64(time.Now().Sub(srs_server_start_time) / time.Millisecond)
+
+	for _, stream := range srs_api_streams() {
+		v.Streams++
+		if stream.Publisher != nil {
+			v.Publishers++
+		}
+	}
+	for _, client := range srs_api_clients() {
+		v.Clients++
+		if client.Type == rtmp.CLIENT_TYPE_Play {
+			v.Players++
+		}
+		v.SendBytes += client.SendBytes
+		v.RecvBytes += client.RecvBytes
+		v.SendKbps += client.SendKbps
+		v.RecvKbps += client.RecvKbps
+	}
+	return v
+}
+func srs_api_vhosts() (vhosts []*SrsApiVhost) {
+	vhosts = []*SrsApiVhost{}
+
+	// the config vhosts in order of name.
+	names := []string{}
+	for name := range srs_config.vhosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	index := map[string]*SrsApiVhost{}
+	for _, name := range names {
+		conf := srs_config.vhosts[name]
+		v := &SrsApiVhost{Name:conf.name, Enabled:conf.enabled, Mode:conf.mode}
+		vhosts = append(vhosts, v)
+		index[name] = v
+	}
+
+	for _, stream := range srs_api_streams() {
+		if v, ok := index[stream.Vhost]; ok {
+			v.Streams++
+		}
+	}
+	for _, client := range srs_api_clients() {
+		if v, ok := index[client.Vhost]; ok {
+			v.Clients++
+			v.SendKbps += client.SendKbps
+			v.RecvKbps += client.RecvKbps
+		}
+	}
+	return
+}
@@ -0,0 +1,123 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the actions of http hooks.
+const SRS_HTTP_HOOKS_ON_CONNECT = "on_connect"
+const SRS_HTTP_HOOKS_ON_CLOSE = "on_close"
+const SRS_HTTP_HOOKS_ON_PUBLISH = "on_publish"
+const SRS_HTTP_HOOKS_ON_UNPUBLISH = "on_unpublish"
+const SRS_HTTP_HOOKS_ON_PLAY = "on_play"
+const SRS_HTTP_HOOKS_ON_STOP = "on_stop"
+
+/**
+* the request body of http hooks, POST to the hook url in json.
+*/
+type SrsHttpHooksRequest struct {
+	Action string `json:"action"`
+	ClientId SrsLogId `json:"client_id"`
+	Ip string `json:"ip"`
+	Vhost string `json:"vhost"`
+	App string `json:"app"`
+	Stream string `json:"stream"`
+	TcUrl string `json:"tcUrl"`
+	PageUrl string `json:"pageUrl"`
+}
+
+/**
+* the response of http hooks, the body is the code, 0 for success,
+* for example, 0 or {"code": 0}
+*/
+type SrsHttpHooksResponse struct {
+	Code *int `json:"code"`
+}
+
+/**
+* notify the http hooks, POST the request to each url in order.
+* @return an error when any url failed or denied.
+*/
+func srs_http_hooks_notify(id SrsLogIdGetter, tag SrsLogTagGetter, urls []string, timeout_ms int, req *SrsHttpHooksRequest) (err error) {
+	var body []byte
+	if body, err = json.Marshal(req); err != nil {
+		return
+	}
+
+	for _, url := range urls {
+		if err = srs_http_hooks_post(url, timeout_ms, body); err != nil {
+			SrsWarn(id, tag, "http hook %v failed, url=%v, err=%v", req.Action, url, err)
+			return
+		}
+		SrsTrace(id, tag, "http hook %v success, url=%v", req.Action, url)
+	}
+	return
+}
+func srs_http_hooks_post(url string, timeout_ms int, body []byte) (err error) {
+	client := &http.Client{Timeout:time.Duration(timeout_ms) * time.Millisecond}
+
+	var res *http.Response
+	if res, err = client.Post(url, "application/json", bytes.NewReader(body)); err != nil {
+		return SrsError{code:ERROR_HTTP_HOOKS_FAILED, desc:fmt.Sprintf("post failed, err=%v", err)}
+	}
+	defer res.Body.Close()
+
+	var data []byte
+	if data, err = ioutil.ReadAll(res.Body); err != nil {
+		return SrsError{code:ERROR_HTTP_HOOKS_FAILED, desc:fmt.Sprintf("read response failed, err=%v", err)}
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return SrsError{code:ERROR_HTTP_HOOKS_FAILED, desc:fmt.Sprintf("response status %v", res.StatusCode)}
+	}
+
+	code, err := srs_http_hooks_parse_code(data)
+	if err != nil {
+		return
+	}
+	if code != 0 {
+		return SrsError{code:ERROR_HTTP_HOOKS_DENIED, desc:fmt.Sprintf("denied by code %v", code)}
+	}
+	return
+}
+func srs_http_hooks_parse_code(data []byte) (code int, err error) {
+	body := strings.TrimSpace(string(data))
+
+	if code, err = strconv.Atoi(body); err == nil {
+		return
+	}
+
+	res := SrsHttpHooksResponse{}
+	if err = json.Unmarshal([]byte(body), &res); err != nil || res.Code == nil {
+		return 0, SrsError{code:ERROR_HTTP_HOOKS_FAILED, desc:fmt.Sprintf("invalid response %v", body)}
+	}
+	return *res.Code, nil
+}
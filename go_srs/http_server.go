@@ -0,0 +1,167 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the max-age in seconds of http cache, the m3u8 changes frequently,
+// while the ts never changes once written.
+const SRS_HTTP_M3U8_MAX_AGE = 1
+const SRS_HTTP_TS_MAX_AGE = 24*3600
+const SRS_HTTP_DEFAULT_MAX_AGE = 60
+
+// the mime types of stream files, which are not registered in most systems.
+var srs_http_mime_types map[string]string = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts": "video/MP2T",
+	".flv": "video/x-flv",
+	".mp4": "video/mp4",
+	".f4v": "video/x-f4v",
+	".swf": "application/x-shockwave-flash",
+	".xml": "text/xml",
+	".html": "text/html",
+	".js": "application/javascript",
+	".css": "text/css",
+}
+
+// the crossdomain.xml for flash player, when not found in dir.
+const SRS_HTTP_CROSSDOMAIN = `<?xml version="1.0"?>
+<cross-domain-policy>
+    <allow-access-from domain="*"/>
+    <allow-http-request-headers-from domain="*" headers="*"/>
+</cross-domain-policy>
+`
+
+/**
+* the http server to deliver the hls and static files in dir.
+*/
+type SrsHttpServer struct {
+	id SrsLogId
+	conf *SrsConfHttpServer
+}
+func NewSrsHttpServer(conf *SrsConfHttpServer) (*SrsHttpServer) {
+	r := &SrsHttpServer{}
+	r.id = SrsGenerateId()
+	r.conf = conf
+	return r
+}
+
+// interface for Log
+func (r *SrsHttpServer) GetId() (SrsLogId) {
+	return r.id
+}
+func (r *SrsHttpServer) GetTag() (SrsLogTag) {
+	return "http"
+}
+
+func (r *SrsHttpServer) Serve() {
+	ep := r.conf.listen
+	if !strings.Contains(ep, ":") {
+		ep = ":" + ep
+	}
+
+	SrsTrace(r, r, "http server listen at %v, dir=%v", ep, r.conf.dir)
+	if err := http.ListenAndServe(ep, r); err != nil {
+		SrsFatal(r, r, "http server listen %v failed, err=%v", ep, err)
+	}
+}
+
+func (r *SrsHttpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// allow the cross domain request for the html5 players.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Range, Origin, Accept, Content-Type")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+	w.Header().Set("Server", RTMP_SIG_SRS_KEY + "/" + RTMP_SIG_SRS_VERSION)
+
+	switch req.Method {
+	case "OPTIONS":
+		return
+	case "GET", "HEAD":
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the clean path never contains .., which is always under dir.
+	upath := path.Clean("/" + req.URL.Path)
+	if upath == "/" {
+		upath = "/index.html"
+	}
+	file := filepath.Join(r.conf.dir, filepath.FromSlash(upath))
+
+	// the hls file in writing is half written, never serve it.
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext == SRS_HLS_TMP_EXT {
+		SrsVerbose(r, r, "http file %v in writing, not found", file)
+		http.NotFound(w, req)
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil && upath == "/crossdomain.xml" {
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, SRS_HTTP_CROSSDOMAIN)
+		return
+	}
+	if err != nil {
+		SrsVerbose(r, r, "http file %v not found, err=%v", file, err)
+		http.NotFound(w, req)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+
+	if mt, ok := srs_http_mime_types[ext]; ok {
+		w.Header().Set("Content-Type", mt)
+	} else if mt := mime.TypeByExtension(ext); mt != "" {
+		w.Header().Set("Content-Type", mt)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	max_age := SRS_HTTP_DEFAULT_MAX_AGE
+	switch ext {
+	case ".m3u8":
+		max_age = SRS_HTTP_M3U8_MAX_AGE
+	case ".ts":
+		max_age = SRS_HTTP_TS_MAX_AGE
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", max_age))
+
+	// serve the content with range and if-modified-since.
+	http.ServeContent(w, req, info.Name(), info.ModTime(), f)
+	SrsVerbose(r, r, "http serve %v, range=%v", file, req.Header.Get("Range"))
+}
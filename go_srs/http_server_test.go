@@ -0,0 +1,71 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHttpServerTmpFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srs-http")
+	if err != nil {
+		t.Fatalf("create dir failed, err=%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the hls in writing, and the reaped segment.
+	if err = os.MkdirAll(filepath.Join(dir, "live"), 0755); err != nil {
+		t.Fatalf("create dir failed, err=%v", err)
+	}
+	for _, name := range []string{ "livestream-0.ts", "livestream-1.ts.tmp", "livestream.m3u8.tmp", "livestream-2.TS.TMP" } {
+		if err = ioutil.WriteFile(filepath.Join(dir, "live", name), []byte("ts"), 0644); err != nil {
+			t.Fatalf("write %v failed, err=%v", name, err)
+		}
+	}
+
+	conf := NewSrsConfHttpServer()
+	conf.dir = dir
+	r := NewSrsHttpServer(conf)
+
+	cases := []struct {
+		url string
+		code int
+	}{
+		{"/live/livestream-0.ts", http.StatusOK},
+		{"/live/livestream-1.ts.tmp", http.StatusNotFound},
+		{"/live/livestream.m3u8.tmp", http.StatusNotFound},
+		{"/live/livestream-2.TS.TMP", http.StatusNotFound},
+		{"/live/livestream.m3u8", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", c.url, nil))
+		if w.Code != c.code {
+			t.Errorf("%v code %v, expect %v", c.url, w.Code, c.code)
+		}
+	}
+}
@@ -21,11 +21,49 @@
 
 package main
 
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
 /**
-* id for log to identify the current client.
+* id for log to identify the current client, unique across restarts and nodes,
+* to join the logs of edge, origin and http hooks, in the format:
+*		[node]-[boot]-[counter]
+* for example, srs1-ka3j2x1c-100, where the node is the node_id in config or hostname,
+* the boot is the start time in ms in base36, the counter is increased for each id.
  */
-var global_id SrsLogId = 0
+var global_id uint64 = 0
+// the node string, atomic for the id is generated by all goroutines.
+var global_id_node *atomic.Value = srs_atomic_string(srs_default_node_id())
+var global_id_boot string = strconv.FormatInt(time.Now().UnixNano() / int64(time.Millisecond), 36)
 func SrsGenerateId() (SrsLogId) {
-	global_id = global_id + 1
-	return global_id
+	id := atomic.AddUint64(&global_id, 1)
+	return SrsLogId(fmt.Sprintf("%v-%v-%v", SrsNodeId(), global_id_boot, id))
+}
+func SrsNodeId() (string) {
+	return global_id_node.Load().(string)
+}
+/**
+* set the node id, generally, when config parsed and before serve clients,
+* the ids generated before keep the old node.
+*/
+func SrsSetNodeId(node string) {
+	if node != "" {
+		global_id_node.Store(node)
+	}
+}
+func srs_atomic_string(v string) (*atomic.Value) {
+	r := &atomic.Value{}
+	r.Store(v)
+	return r
+}
+func srs_default_node_id() (string) {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "srs"
 }
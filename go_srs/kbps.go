@@ -0,0 +1,95 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+/**
+* the bytes and kbps of client or stream, for the http api,
+* the kbps is sampled in the SRS_KBPS_SAMPLE_MS when queried,
+* and the average kbps before the first sample.
+*/
+type SrsKbps struct {
+	lock *sync.Mutex
+	created time.Time
+	send_bytes uint64
+	recv_bytes uint64
+	// the sample to calc the current kbps.
+	sampled bool
+	sample_time time.Time
+	sample_send_bytes uint64
+	sample_recv_bytes uint64
+	send_kbps int
+	recv_kbps int
+}
+func NewSrsKbps() (*SrsKbps) {
+	r := &SrsKbps{}
+	r.lock = &sync.Mutex{}
+	r.created = time.Now()
+	r.sample_time = r.created
+	return r
+}
+func (r *SrsKbps) OnSend(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.send_bytes += uint64(n)
+}
+func (r *SrsKbps) OnRecv(n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.recv_bytes += uint64(n)
+}
+/**
+* the time since created.
+*/
+func (r *SrsKbps) Age() (time.Duration) {
+	return time.Now().Sub(r.created)
+}
+func (r *SrsKbps) Bytes() (send_bytes uint64, recv_bytes uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.send_bytes, r.recv_bytes
+}
+/**
+* get the kbps, sample it when the last sample expired.
+*/
+func (r *SrsKbps) Kbps() (send_kbps int, recv_kbps int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	if elapse := now.Sub(r.sample_time); elapse >= SRS_KBPS_SAMPLE_MS * time.Millisecond {
+		r.send_kbps = srs_bandwidth_kbps(r.send_bytes - r.sample_send_bytes, elapse)
+		r.recv_kbps = srs_bandwidth_kbps(r.recv_bytes - r.sample_recv_bytes, elapse)
+		r.sample_time, r.sample_send_bytes, r.sample_recv_bytes = now, r.send_bytes, r.recv_bytes
+		r.sampled = true
+	}
+
+	if !r.sampled {
+		elapse := now.Sub(r.created)
+		return srs_bandwidth_kbps(r.send_bytes, elapse), srs_bandwidth_kbps(r.recv_bytes, elapse)
+	}
+	return r.send_kbps, r.recv_kbps
+}
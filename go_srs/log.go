@@ -22,14 +22,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 /**
 * id for log to identify the current client.
  */
-type SrsLogId uint64
+type SrsLogId string
 type SrsLogIdGetter interface {
 	GetId() (SrsLogId)
 }
@@ -42,20 +46,217 @@ type SrsLogTagGetter interface {
 	GetTag() (SrsLogTag)
 }
 
+/**
+* the optional interface of id getter, to use the log level of vhost,
+* return nil when vhost unknown.
+ */
+type SrsLogVhostGetter interface {
+	GetLogVhost() (*SrsConfVhost)
+}
+
+/**
+* the optional interface of id getter, the structured fields of context,
+* for example, the vhost/app/stream and remote addr of client,
+* which is written to the json log.
+ */
+type SrsLogFieldsGetter interface {
+	GetLogFields() (map[string]interface{})
+}
+
+// the log level, print the log whose level is not less than the level.
+const SRS_LOG_LEVEL_VERBOSE = 0
+const SRS_LOG_LEVEL_INFO = 1
+const SRS_LOG_LEVEL_TRACE = 2
+const SRS_LOG_LEVEL_WARN = 3
+const SRS_LOG_LEVEL_ERROR = 4
+// the log level is not set, for example, use the global level for vhost.
+const SRS_LOG_LEVEL_UNKNOWN = -1
+
+// the log tank, write log to console or file.
+const SRS_LOG_TANK_CONSOLE = "console"
+const SRS_LOG_TANK_FILE = "file"
+
+// the log format, the text line or the json object per line.
+const SRS_LOG_FORMAT_TEXT = "text"
+const SRS_LOG_FORMAT_JSON = "json"
+
+/**
+* parse the level from name, for example, trace.
+ */
+func SrsParseLogLevel(name string) (level int, ok bool) {
+	switch name {
+	case "verbose":
+		return SRS_LOG_LEVEL_VERBOSE, true
+	case "info":
+		return SRS_LOG_LEVEL_INFO, true
+	case "trace":
+		return SRS_LOG_LEVEL_TRACE, true
+	case "warn":
+		return SRS_LOG_LEVEL_WARN, true
+	case "error":
+		return SRS_LOG_LEVEL_ERROR, true
+	}
+	return SRS_LOG_LEVEL_UNKNOWN, false
+}
+func srs_log_level_name(level int) (string) {
+	switch level {
+	case SRS_LOG_LEVEL_VERBOSE:
+		return "verbose"
+	case SRS_LOG_LEVEL_INFO:
+		return "info"
+	case SRS_LOG_LEVEL_TRACE:
+		return "trace"
+	case SRS_LOG_LEVEL_WARN:
+		return "warn"
+	}
+	return "error"
+}
+
+/**
+* the logger write the log to console or file,
+* the file is reopened when SIGUSR1 for logrotate.
+ */
+type SrsLogger struct {
+	// the global level, changed at runtime.
+	level int32
+	// the lock for the file and format.
+	lock *sync.Mutex
+	format string
+	tank string
+	file string
+	f *os.File
+}
+var srs_log *SrsLogger = NewSrsLogger()
+func NewSrsLogger() (*SrsLogger) {
+	r := &SrsLogger{}
+	r.level = SRS_LOG_LEVEL_TRACE
+	r.lock = &sync.Mutex{}
+	r.format = SRS_LOG_FORMAT_TEXT
+	r.tank = SRS_LOG_TANK_CONSOLE
+	return r
+}
+
+/**
+* open the log tank, the file is created or appended.
+ */
+func (r *SrsLogger) Open(tank string, file string) (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.tank, r.file = tank, file
+	return r.open()
+}
+/**
+* reopen the log file, for the logrotate which moved the file.
+ */
+func (r *SrsLogger) Reopen() (err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.open()
+}
+func (r *SrsLogger) open() (err error) {
+	if r.tank != SRS_LOG_TANK_FILE {
+		return
+	}
+
+	var f *os.File
+	if f, err = os.OpenFile(r.file, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644); err != nil {
+		return
+	}
+
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.f = f
+	return
+}
+func (r *SrsLogger) SetFormat(format string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.format = format
+}
+func (r *SrsLogger) SetLevel(level int) {
+	atomic.StoreInt32(&r.level, int32(level))
+}
+func (r *SrsLogger) Level() (int) {
+	return int(atomic.LoadInt32(&r.level))
+}
+/**
+* write the log in the text format:
+*		[level][time][id][tag]log
+* or the json format, a object per line:
+*		{"level":"trace","ts":"...","cid":"...","tag":"...","msg":"...","vhost":"..."}
+ */
+func (r *SrsLogger) write(level int, name string, id SrsLogIdGetter, tag SrsLogTagGetter, format string, a ...interface{}) {
+	// the level of vhost overrides the global level.
+	min_level := r.Level()
+	if g, ok := id.(SrsLogVhostGetter); ok {
+		if vhost := g.GetLogVhost(); vhost != nil && vhost.LogLevel() != SRS_LOG_LEVEL_UNKNOWN {
+			min_level = vhost.LogLevel()
+		}
+	}
+	if level < min_level {
+		return
+	}
+
+	now := time.Now()
+	msg := fmt.Sprintf(format, a...)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var log string
+	if r.format == SRS_LOG_FORMAT_JSON {
+		log = srs_log_json(level, now, id, tag, msg)
+	} else {
+		log = fmt.Sprintf("[%v][%v][%v][%v]%v\n", name, now.Format("2006-01-02 15:04:05"),
+			id.GetId(), tag.GetTag(), msg)
+	}
+
+	if r.f != nil {
+		r.f.WriteString(log)
+		return
+	}
+	os.Stdout.WriteString(log)
+}
+func srs_log_json(level int, now time.Time, id SrsLogIdGetter, tag SrsLogTagGetter, msg string) (string) {
+	obj := map[string]interface{}{}
+	// the fields of context never override the standard fields.
+	if g, ok := id.(SrsLogFieldsGetter); ok {
+		for k, v := range g.GetLogFields() {
+			obj[k] = v
+		}
+	}
+	obj["level"] = srs_log_level_name(level)
+	obj["ts"] = now.Format("2006-01-02T15:04:05.000Z07:00")
+	obj["cid"] = id.GetId()
+	obj["tag"] = tag.GetTag()
+	obj["msg"] = msg
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"level": "error", "ts": obj["ts"], "cid": obj["cid"], "tag": obj["tag"],
+			"msg": fmt.Sprintf("marshal log failed, err=%v, msg=%v", err, msg),
+		})
+	}
+	return string(b) + "\n"
+}
+
 func SrsFatal(id SrsLogIdGetter, tag SrsLogTagGetter, format string, a ...interface{}) {
-	fmt.Printf(fmt.Sprintf("[Fatal][%v][%v][%v]%v\n", time.Now().Format("2006-01-02 15:04:05"), id.GetId(), tag.GetTag(), format), a...)
+	srs_log.write(SRS_LOG_LEVEL_ERROR, "Fatal", id, tag, format, a...)
 }
 func SrsWarn(id SrsLogIdGetter, tag SrsLogTagGetter, format string, a ...interface{}) {
-	fmt.Printf(fmt.Sprintf("[Warn0][%v][%v][%v]%v\n", time.Now().Format("2006-01-02 15:04:05"), id.GetId(), tag.GetTag(), format), a...)
+	srs_log.write(SRS_LOG_LEVEL_WARN, "Warn0", id, tag, format, a...)
 }
 func SrsTrace(id SrsLogIdGetter, tag SrsLogTagGetter, format string, a ...interface{}) {
-	fmt.Printf(fmt.Sprintf("[Trace][%v][%v][%v]%v\n", time.Now().Format("2006-01-02 15:04:05"), id.GetId(), tag.GetTag(), format), a...)
+	srs_log.write(SRS_LOG_LEVEL_TRACE, "Trace", id, tag, format, a...)
 }
 func SrsInfo(id SrsLogIdGetter, tag SrsLogTagGetter, format string, a ...interface{}) {
-	return
-	fmt.Printf(fmt.Sprintf("[Info0][%v][%v][%v]%v\n", time.Now().Format("2006-01-02 15:04:05"), id.GetId(), tag.GetTag(), format), a...)
+	srs_log.write(SRS_LOG_LEVEL_INFO, "Info0", id, tag, format, a...)
 }
 func SrsVerbose(id SrsLogIdGetter, tag SrsLogTagGetter, format string, a ...interface{}) {
-	return
-	fmt.Printf(fmt.Sprintf("[Verbs][%v][%v][%v]%v\n", time.Now().Format("2006-01-02 15:04:05"), id.GetId(), tag.GetTag(), format), a...)
+	srs_log.write(SRS_LOG_LEVEL_VERBOSE, "Verbs", id, tag, format, a...)
 }
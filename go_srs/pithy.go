@@ -0,0 +1,158 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the stage of pithy print, the clients of a stage
+// print at most one line in the interval.
+const SRS_STAGE_PUBLISH = "publish"
+const SRS_STAGE_PLAY = "play"
+const SRS_STAGE_FORWARDER = "forwarder"
+const SRS_STAGE_EDGE = "edge"
+
+var pithy_stages map[string]*SrsPithyStage = map[string]*SrsPithyStage{}
+var pithy_stages_lock *sync.Mutex = &sync.Mutex{}
+
+/**
+* the stage of pithy print, shared by all clients of the stage.
+*/
+type SrsPithyStage struct {
+	name string
+	lock *sync.Mutex
+	// the clients of stage.
+	clients int
+	// the last time any client of stage printed.
+	last_print time.Time
+}
+func srs_pithy_stage(name string) (*SrsPithyStage) {
+	pithy_stages_lock.Lock()
+	defer pithy_stages_lock.Unlock()
+
+	if _, ok := pithy_stages[name]; !ok {
+		pithy_stages[name] = &SrsPithyStage{name:name, lock:&sync.Mutex{}, last_print:time.Now()}
+	}
+	return pithy_stages[name]
+}
+func (r *SrsPithyStage) interval() (time.Duration) {
+	conf := srs_config.pithy_print
+
+	ms := conf.publish_ms
+	switch r.name {
+	case SRS_STAGE_PLAY:
+		ms = conf.play_ms
+	case SRS_STAGE_FORWARDER:
+		ms = conf.forwarder_ms
+	case SRS_STAGE_EDGE:
+		ms = conf.edge_ms
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+/**
+* the pithy print for a client, collect the bytes and calc the kbps,
+* print the progress when it's the turn of stage, for example:
+*		pp := NewSrsPithyPrint(SRS_STAGE_PLAY)
+*		defer pp.Close()
+*		for {
+*			pp.OnSend(len(msg.Payload))
+*			if pp.CanPrint() {
+*				SrsTrace(r, r, "-> play %v, queue=%v", pp, consumer.Len())
+*			}
+*		}
+*/
+type SrsPithyPrint struct {
+	stage *SrsPithyStage
+	created time.Time
+	send_bytes uint64
+	recv_bytes uint64
+	// the sample to calc the current kbps.
+	sample_time time.Time
+	sample_send_bytes uint64
+	sample_recv_bytes uint64
+	send_kbps int
+	recv_kbps int
+	// the clients of stage when print.
+	clients int
+}
+func NewSrsPithyPrint(stage string) (*SrsPithyPrint) {
+	r := &SrsPithyPrint{}
+	r.stage = srs_pithy_stage(stage)
+	r.created = time.Now()
+	r.sample_time = r.created
+
+	r.stage.lock.Lock()
+	defer r.stage.lock.Unlock()
+	r.stage.clients++
+	return r
+}
+/**
+* the client quit the stage.
+*/
+func (r *SrsPithyPrint) Close() {
+	r.stage.lock.Lock()
+	defer r.stage.lock.Unlock()
+	r.stage.clients--
+}
+func (r *SrsPithyPrint) OnSend(n int) {
+	r.send_bytes += uint64(n)
+}
+func (r *SrsPithyPrint) OnRecv(n int) {
+	r.recv_bytes += uint64(n)
+}
+/**
+* update the kbps, and whether the client should print now,
+* only one client of stage prints in the interval.
+*/
+func (r *SrsPithyPrint) CanPrint() (bool) {
+	now := time.Now()
+	interval := r.stage.interval()
+
+	// sample the kbps in the interval.
+	if elapse := now.Sub(r.sample_time); elapse >= interval {
+		r.send_kbps = srs_bandwidth_kbps(r.send_bytes - r.sample_send_bytes, elapse)
+		r.recv_kbps = srs_bandwidth_kbps(r.recv_bytes - r.sample_recv_bytes, elapse)
+		r.sample_time, r.sample_send_bytes, r.sample_recv_bytes = now, r.send_bytes, r.recv_bytes
+	}
+
+	r.stage.lock.Lock()
+	defer r.stage.lock.Unlock()
+
+	if now.Sub(r.stage.last_print) < interval {
+		return false
+	}
+	r.stage.last_print = now
+	r.clients = r.stage.clients
+	return true
+}
+/**
+* the age in ms, bytes and kbps of client.
+*/
+func (r *SrsPithyPrint) String() (string) {
+	return fmt.Sprintf("clients=%v, age=%vms, send=%vB(%vkbps), recv=%vB(%vkbps)",
+		r.clients, int64(time.Now().Sub(r.created) / time.Millisecond),
+		r.send_bytes, r.send_kbps, r.recv_bytes, r.recv_kbps)
+}
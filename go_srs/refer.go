@@ -0,0 +1,62 @@
+// The MIT License (MIT)
+//
+// Copyright (c) 2014 winlin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+* check the page url of client by the refer domains,
+* the domain of page url must be the refer, or the sub domain of refer,
+* for example, the refer github.com matches:
+*		http://github.com/winlinvip/player.html
+*		https://pages.github.com:8080/player.html
+* @param refers the allowed domains, allow all when empty.
+*/
+func srs_refer_check(page_url string, refers []string) (err error) {
+	if len(refers) == 0 {
+		return
+	}
+
+	domain := page_url
+	if pos := strings.Index(domain, "://"); pos >= 0 {
+		domain = domain[pos + 3:]
+	}
+	if pos := strings.Index(domain, "/"); pos >= 0 {
+		domain = domain[:pos]
+	}
+	if pos := strings.Index(domain, ":"); pos >= 0 {
+		domain = domain[:pos]
+	}
+	domain = strings.ToLower(domain)
+
+	for _, refer := range refers {
+		refer = strings.ToLower(refer)
+		if domain == refer || strings.HasSuffix(domain, "." + refer) {
+			return
+		}
+	}
+
+	return SrsError{code:ERROR_RTMP_ACCESS_DENIED, desc:fmt.Sprintf("refer check failed, pageUrl=%v, refers=%v", page_url, refers)}
+}
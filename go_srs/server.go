@@ -23,6 +23,12 @@ package main
 
 import (
 	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 	"github.com/winlinvip/go.rtmp/rtmp"
 )
 
@@ -48,20 +54,149 @@ func (r *SrsServer) PrintInfo() {
 	SrsTrace(r, r, "RTMP Protocol Stack:  %v", rtmp.Version)
 }
 
+/**
+* load the config file, use the default config when conf is empty.
+*/
+func (r *SrsServer) Initialize(conf string) (err error) {
+	if conf == "" {
+		SrsTrace(r, r, "no config file specified, use the default config")
+		return
+	}
+
+	if err = srs_config.ParseFile(conf); err != nil {
+		SrsFatal(r, r, "parse config failed, err=%v", err)
+		return
+	}
+	SrsSetNodeId(srs_config.node_id)
+	// the id of server is generated before config parsed, regenerate it by the node id.
+	r.id = SrsGenerateId()
+
+	if err = srs_log.Open(srs_config.log_tank, srs_config.log_file); err != nil {
+		SrsFatal(r, r, "open log %v failed, err=%v", srs_config.log_file, err)
+		return
+	}
+	srs_log.SetLevel(srs_config.log_level)
+	srs_log.SetFormat(srs_config.log_format)
+	SrsTrace(r, r, "parse config %v success, listen=%v, vhosts=%v", conf, srs_config.listen, len(srs_config.vhosts))
+	return
+}
+
 func (r *SrsServer) Serve() {
-	addr, err := net.ResolveTCPAddr("tcp4", ":1935")
+	var wg sync.WaitGroup
+
+	if srs_config.http_server.enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewSrsHttpServer(srs_config.http_server).Serve()
+		}()
+	}
+
+	if srs_config.http_api.enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewSrsHttpApi(srs_config.http_api).Serve()
+		}()
+	}
+
+	go r.reap_cycle()
+	go r.signal_cycle()
+
+	for _, ep := range srs_config.listen {
+		wg.Add(1)
+		go func(ep string) {
+			defer wg.Done()
+			r.listen_cycle(ep)
+		}(ep)
+	}
+	wg.Wait()
+}
+/**
+* reopen the log file when SIGUSR1, for example, the logrotate,
+* and reload the log level, for example, to debug online.
+*/
+func (r *SrsServer) signal_cycle() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	for {
+		<- signals
+		if err := srs_log.Reopen(); err != nil {
+			SrsWarn(r, r, "reopen log failed, err=%v", err)
+		} else {
+			SrsTrace(r, r, "reopen log success")
+		}
+		r.reload_log_level()
+	}
+}
+/**
+* reload the global log level and the log level of vhosts from the config file,
+* the other directives and the vhosts added or removed are never reloaded.
+*/
+func (r *SrsServer) reload_log_level() {
+	if srs_config.file == "" {
+		return
+	}
+
+	conf := NewSrsConfig()
+	if err := conf.ParseFile(srs_config.file); err != nil {
+		SrsWarn(r, r, "reload log level failed, err=%v", err)
+		return
+	}
+	srs_log.SetLevel(conf.log_level)
+	SrsTrace(r, r, "reload log level to %v", srs_log_level_name(conf.log_level))
+
+	// the vhosts never changed after config parsed, only update the level.
+	for name, vhost := range srs_config.vhosts {
+		level := SRS_LOG_LEVEL_UNKNOWN
+		if v, ok := conf.vhosts[name]; ok {
+			level = v.LogLevel()
+		}
+		if vhost.LogLevel() == level {
+			continue
+		}
+
+		vhost.SetLogLevel(level)
+		if level == SRS_LOG_LEVEL_UNKNOWN {
+			SrsTrace(r, r, "reload log level of vhost %v to the global level", name)
+		} else {
+			SrsTrace(r, r, "reload log level of vhost %v to %v", name, srs_log_level_name(level))
+		}
+	}
+}
+/**
+* cleanup the idle sources, to free the memory.
+*/
+func (r *SrsServer) reap_cycle() {
+	for {
+		time.Sleep(SRS_SOURCE_REAP_INTERVAL_MS * time.Millisecond)
+
+		for _, stream_url := range ReapSrsSources() {
+			SrsTrace(r, r, "cleanup idle source %v", stream_url)
+		}
+	}
+}
+func (r *SrsServer) listen_cycle(ep string) {
+	// the endpoint is port only, for example, 1935
+	if !strings.Contains(ep, ":") {
+		ep = ":" + ep
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp4", ep)
 	if err != nil {
-		SrsFatal(r, r, "resolve listen address failed, err=%v", err)
+		SrsFatal(r, r, "resolve listen address %v failed, err=%v", ep, err)
 		return;
 	}
 
 	var listener *net.TCPListener
 	listener, err = net.ListenTCP("tcp4", addr)
 	if err != nil {
-		SrsFatal(r, r, "listen failed, err=%v", err)
+		SrsFatal(r, r, "listen %v failed, err=%v", ep, err)
 		return;
 	}
 	defer listener.Close()
+	SrsTrace(r, r, "rtmp listen at %v", ep)
 
 	for {
 		SrsVerbose(r, r, "listener ready to accept client")
@@ -74,13 +209,13 @@ func (r *SrsServer) Serve() {
 		serve := func(conn *net.TCPConn) {
 			defer conn.Close()
 
-			var client *SrsClient
-			if client, err = NewSrsClient(conn); err != nil {
+			client, err := NewSrsClient(conn)
+			if err != nil {
 				SrsFatal(r, r, "create client failed, err=%v", err)
 				return
 			}
 
-			err = client.do_cycle()
+			client.do_cycle()
 		}
 		go serve(conn)
 	}
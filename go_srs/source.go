@@ -22,22 +22,180 @@
 package main
 
 import (
+	"fmt"
 	"github.com/winlinvip/go.rtmp/rtmp"
 	"container/list"
 	"sync"
+	"time"
 )
 
+// the sources by stream url, cleanup when idle.
 var source_pool map[string]*SrsSource = map[string]*SrsSource{}
+var source_pool_lock *sync.Mutex = &sync.Mutex{}
+
+// when there are too many audio messages after the last video,
+// for example, 115 aac frames is about 3s, guess it's pure audio stream.
+const SRS_PURE_AUDIO_GUESS_COUNT = 115
+
+/**
+* cache the last gop of video and audio, to send to the play client
+* immediately, then the player can show picture without waiting for
+* the next keyframe.
+* for pure audio stream, cache the last audio messages instead.
+*/
+type SrsGopCache struct {
+	// whether gop cache is enabled.
+	enabled bool
+	// the max size in bytes, duration in ms and count of messages,
+	// the cache is cleared or shrinked when exceed.
+	max_size int
+	max_duration uint64
+	max_msgs int
+	// the cached messages, starts with a video keyframe,
+	// or only audio messages for pure audio stream.
+	msgs []*rtmp.Message
+	// the size of all cached messages.
+	size int
+	// whether the cache starts with a video keyframe.
+	has_video bool
+	// the audio messages after last video, to guess pure audio stream.
+	audio_after_last_video int
+}
+func NewSrsGopCache(vhost *SrsConfVhost) (*SrsGopCache) {
+	r := &SrsGopCache{}
+	r.enabled = vhost.gop_cache
+	r.max_size = vhost.gop_cache_max_size
+	r.max_duration = uint64(vhost.gop_cache_max_duration_ms)
+	// the cache dumps to the queue of consumer, never overflow it.
+	r.max_msgs = vhost.queue_length / 2
+	return r
+}
+func (r *SrsGopCache) Set(enabled bool) {
+	r.enabled = enabled
+	if !enabled {
+		r.Clear()
+	}
+}
+func (r *SrsGopCache) Clear() {
+	r.msgs = nil
+	r.size = 0
+	r.has_video = false
+	r.audio_after_last_video = 0
+}
+/**
+* cache the audio or video message.
+*/
+func (r *SrsGopCache) Cache(msg *rtmp.Message) {
+	if !r.enabled {
+		return
+	}
+
+	// the sequence headers are cached by source.
+	if msg.Header.IsVideo() && srs_codec_video_is_sequence_header(msg.Payload) {
+		return
+	}
+	if msg.Header.IsAudio() && srs_codec_audio_is_sequence_header(msg.Payload) {
+		return
+	}
+
+	if msg.Header.IsVideo() {
+		r.audio_after_last_video = 0
+
+		// the new gop starts with keyframe.
+		if srs_codec_video_is_keyframe(msg.Payload) {
+			r.Clear()
+			r.has_video = true
+		}
+
+		// ignore the video before the first keyframe.
+		if !r.has_video {
+			return
+		}
+	}
+
+	if msg.Header.IsAudio() && r.has_video {
+		// too many audio after last video, the stream turns to pure audio.
+		if r.audio_after_last_video++; r.audio_after_last_video > SRS_PURE_AUDIO_GUESS_COUNT {
+			r.Clear()
+		}
+	}
+
+	r.msgs = append(r.msgs, msg.Copy())
+	r.size += len(msg.Payload)
+
+	// the gop is too large, clear it and wait for the next keyframe.
+	if r.has_video {
+		if r.overflow() {
+			r.Clear()
+		}
+		return
+	}
+
+	// for pure audio, drop the oldest audio.
+	for len(r.msgs) > 0 && r.overflow() {
+		r.size -= len(r.msgs[0].Payload)
+		r.msgs = r.msgs[1:]
+	}
+}
+func (r *SrsGopCache) overflow() (bool) {
+	if r.size > r.max_size || len(r.msgs) > r.max_msgs {
+		return true
+	}
+	if len(r.msgs) > 1 {
+		first, last := r.msgs[0].Header.Timestamp, r.msgs[len(r.msgs) - 1].Header.Timestamp
+		if last > first && last - first > r.max_duration {
+			return true
+		}
+	}
+	return false
+}
+/**
+* dump the cached messages to the consumer.
+*/
+func (r *SrsGopCache) Dump(consumer *SrsConsumer, tba int, tbv int) (err error) {
+	for _, msg := range r.msgs {
+		if err = consumer.OnMessage(msg.Copy(), tba, tbv); err != nil {
+			return
+		}
+	}
+	return
+}
 
 /**
 * live streaming source.
 */
 type SrsSource struct {
+	id SrsLogId
 	// the identified request from client.
 	req *rtmp.Request
+	// the config of vhost.
+	vhost *SrsConfVhost
 	// the consumer list
 	consumers *list.List
 	consumers_lock *sync.Mutex
+	// the gop cache for client fast startup.
+	gop_cache *SrsGopCache
+	// the cached onMetaData, without @setDataFrame.
+	cache_metadata *rtmp.Message
+	// the cached sequence headers, the sps/pps of h.264 and AudioSpecificConfig of aac.
+	cache_sh_video *rtmp.Message
+	cache_sh_audio *rtmp.Message
+	// the client which is publishing the stream, nil if not publishing.
+	publisher *SrsClient
+	// the hls to deliver stream in hls.
+	hls *SrsHls
+	// the forwarders to forward stream to other servers.
+	forwarders []*SrsForwarder
+	// the encoders to transcode the stream.
+	encoders []*SrsEncoder
+	// the ingester for edge to play stream from origin, nil for origin.
+	edge *SrsEdgeIngester
+	// the clients which found the source and not released.
+	refs int
+	// the time when source become idle, no client and no publisher.
+	idle_at time.Time
+	// the bytes and kbps received from publisher or origin.
+	kbps *SrsKbps
 	/**
 	* the sample rate of audio in metadata.
 	*/
@@ -52,26 +210,302 @@ type SrsSource struct {
 * @param req the client request.
 * @return the matched source, never be NULL.
 * @remark stream_url should without port and schema.
+* @remark the client must release the source when done.
 */
 func FindSrsSource(req *rtmp.Request) (*SrsSource) {
+	source_pool_lock.Lock()
+	defer source_pool_lock.Unlock()
+
 	stream_url := req.StreamUrl()
 	if _, ok := source_pool[stream_url]; !ok {
 		r := &SrsSource{}
-		r.req = req
+		r.id = SrsGenerateId()
+		// copy the request, the client may change it when re-identify.
+		r.req = &rtmp.Request{}
+		*r.req = *req
+		r.vhost = srs_config.GetVhost(req.Vhost)
 		r.consumers = list.New()
 		r.consumers_lock = &sync.Mutex{}
+		r.gop_cache = NewSrsGopCache(r.vhost)
+		r.kbps = NewSrsKbps()
+		r.hls = NewSrsHls(r)
+		if r.vhost.IsEdge() {
+			r.edge = NewSrsEdgeIngester(r)
+		}
 
 		source_pool[stream_url] = r
 	}
-	return source_pool[stream_url]
+
+	r := source_pool[stream_url]
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	r.refs++
+	return r
+}
+/**
+* cleanup the sources idle for the source_idle_timeout of vhost,
+* the idle source has no client, no publisher and no edge ingest.
+* @return the stream url of sources removed.
+*/
+func ReapSrsSources() (reaped []string) {
+	source_pool_lock.Lock()
+	defer source_pool_lock.Unlock()
+
+	for stream_url, r := range source_pool {
+		if r.reap() {
+			delete(source_pool, stream_url)
+			reaped = append(reaped, stream_url)
+		}
+	}
+	return
+}
+/**
+* list all sources, for the http api.
+*/
+func ListSrsSources() (sources []*SrsSource) {
+	source_pool_lock.Lock()
+	defer source_pool_lock.Unlock()
+
+	for _, r := range source_pool {
+		sources = append(sources, r)
+	}
+	return
+}
+/**
+* release the source found by FindSrsSource, the source is idle
+* when all clients released.
+*/
+func (r *SrsSource) Release() {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	if r.refs--; r.refs == 0 {
+		r.idle_at = time.Now()
+	}
+}
+/**
+* whether the source is idle for the idle timeout, dispose it when idle.
+*/
+func (r *SrsSource) reap() (bool) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	if r.refs > 0 || r.publisher != nil || r.consumers.Len() > 0 {
+		return false
+	}
+	if r.edge != nil {
+		if state, _ := r.edge.State(); state != SRS_EDGE_STATE_STOPPED {
+			return false
+		}
+	}
+
+	timeout := time.Duration(r.vhost.source_idle_timeout_ms) * time.Millisecond
+	if time.Now().Sub(r.idle_at) < timeout {
+		return false
+	}
+
+	// cleanup the cached gop, metadata and sequence headers.
+	r.on_unpublish()
+	return true
+}
+// interface for Log
+func (r *SrsSource) GetId() (SrsLogId) {
+	return r.id
+}
+func (r *SrsSource) GetTag() (SrsLogTag) {
+	return "source"
+}
+func (r *SrsSource) GetLogVhost() (*SrsConfVhost) {
+	return r.vhost
+}
+func (r *SrsSource) GetLogFields() (map[string]interface{}) {
+	return map[string]interface{}{
+		"vhost": r.req.Vhost, "app": r.req.App, "stream": r.req.Stream,
+	}
+}
+
+/**
+* enable or disable the gop cache.
+*/
+func (r *SrsSource) SetCache(enabled bool) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	r.gop_cache.Set(enabled)
+}
+/**
+* get the client which is publishing, nil if not publishing.
+*/
+func (r *SrsSource) Publisher() (*SrsClient) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	return r.publisher
+}
+/**
+* the statistic of source, the publisher, codec and kbps, for the http api.
+*/
+func (r *SrsSource) Stat() (v *SrsApiStream) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	v = &SrsApiStream{Id:r.id, Url:r.req.StreamUrl(), Vhost:r.req.Vhost, App:r.req.App, Stream:r.req.Stream}
+	if r.publisher != nil {
+		v.Publisher = &SrsApiPublisher{Id:r.publisher.GetId(), Ip:r.publisher.Ip()}
+	}
+	if r.edge != nil {
+		state, origin := r.edge.State()
+		v.Edge = &SrsApiEdge{State:state, Origin:origin}
+	}
+
+	v.Clients = r.consumers.Len()
+	for p := r.consumers.Front(); p != nil; p = p.Next() {
+		v.Dropped += p.Value.(*SrsConsumer).Dropped()
+	}
+	_, v.RecvBytes = r.kbps.Bytes()
+	_, v.RecvKbps = r.kbps.Kbps()
+
+	// the codec info from the cached sequence headers.
+	codec := NewSrsCodec()
+	if r.cache_sh_video != nil && codec.DecodeVideoSequenceHeader(r.cache_sh_video.Payload) == nil {
+		v.Video = &SrsApiVideo{Codec:"H264", Profile:int(codec.avc_profile), Level:int(codec.avc_level)}
+	}
+	if r.cache_sh_audio != nil && codec.DecodeAudioSequenceHeader(r.cache_sh_audio.Payload) == nil {
+		v.Audio = &SrsApiAudio{Codec:"AAC", Object:int(codec.aac_object),
+			SampleRate:codec.AacSampleRate(), Channels:int(codec.aac_channels)}
+	}
+
+	v.Forwarders = []*SrsApiForwarder{}
+	for _, forwarder := range r.forwarders {
+		state, send_bytes, last_error := forwarder.State()
+		f := &SrsApiForwarder{Destination:forwarder.destination, State:state, SendBytes:send_bytes}
+		if last_error != nil {
+			f.Error = last_error.Error()
+		}
+		v.Forwarders = append(v.Forwarders, f)
+	}
+	return
+}
+/**
+* acquire the publish of source, only one publisher at a time.
+* @param takeover whether kick the current publisher.
+* @return true when acquired, false when stream is busy.
+*/
+func (r *SrsSource) AcquirePublish(client *SrsClient, takeover bool) (bool) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	if r.publisher != nil && !takeover {
+		return false
+	}
+
+	if r.publisher != nil {
+		r.publisher.Kick(fmt.Sprintf("publisher takeover by client %v", client.GetId()))
+		r.on_unpublish()
+	}
+
+	r.publisher = client
+	return true
+}
+/**
+* release the publish of source, when publisher stop or disconnect.
+*/
+func (r *SrsSource) ReleasePublish(client *SrsClient) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	// the publisher maybe takeover by another client.
+	if r.publisher != client {
+		return
+	}
+
+	r.on_unpublish()
+	r.publisher = nil
+}
+/**
+* when publisher start to publish, notify the hls to prepare,
+* and start the forwarders and encoders.
+*/
+func (r *SrsSource) OnPublish() {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	r.hls.OnPublish(r.req)
+
+	for _, destination := range r.vhost.forward {
+		forwarder := NewSrsForwarder(r, destination)
+		forwarder.OnPublish(r.req)
+		r.forwarders = append(r.forwarders, forwarder)
+	}
+
+	if r.vhost.transcode.enabled {
+		for _, engine := range r.vhost.transcode.engines {
+			encoder := NewSrsEncoder(r, engine)
+			encoder.OnPublish(r.req)
+			r.encoders = append(r.encoders, encoder)
+		}
+	}
+}
+/**
+* when the edge ingest stop, cleanup the stream as publisher unpublish.
+*/
+func (r *SrsSource) OnUnpublish() {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	r.on_unpublish()
+}
+/**
+* cleanup the cache of stream when unpublish,
+* for the new publisher may use different codec.
+*/
+func (r *SrsSource) on_unpublish() {
+	r.hls.OnUnpublish()
+
+	for _, forwarder := range r.forwarders {
+		forwarder.OnUnpublish()
+	}
+	r.forwarders = nil
+
+	for _, encoder := range r.encoders {
+		encoder.OnUnpublish()
+	}
+	r.encoders = nil
+
+	r.gop_cache.Clear()
+	r.cache_metadata = nil
+	r.cache_sh_video = nil
+	r.cache_sh_audio = nil
 }
-func (r *SrsSource) CreateConsumer() (*SrsConsumer) {
+func (r *SrsSource) CreateConsumer() (v *SrsConsumer, err error) {
 	r.consumers_lock.Lock()
 	defer r.consumers_lock.Unlock()
 
-	v := NewSrsConsumer(r)
+	v = NewSrsConsumer(r)
+
+	// copy metadata and sequence headers, for the decoder to initialize.
+	for _, msg := range []*rtmp.Message{ r.cache_metadata, r.cache_sh_video, r.cache_sh_audio } {
+		if msg == nil {
+			continue
+		}
+		if err = v.OnMessage(msg.Copy(), r.sample_rate, r.frame_rate); err != nil {
+			return
+		}
+	}
+
+	// copy the gop cache to the consumer, before any live message.
+	if err = r.gop_cache.Dump(v, r.sample_rate, r.frame_rate); err != nil {
+		return
+	}
+
 	v.elem = r.consumers.PushBack(v)
-	return v
+
+	// the edge play the stream from origin when player comes.
+	if r.edge != nil {
+		r.edge.OnPlay()
+	}
+	return
 }
 func (r *SrsSource) RemoveConsumer(v *SrsConsumer){
 	r.consumers_lock.Lock()
@@ -80,13 +514,107 @@ func (r *SrsSource) RemoveConsumer(v *SrsConsumer){
 	if v.elem != nil {
 		r.consumers.Remove(v.elem)
 	}
+
+	// the edge stop the ingest when no player for a while.
+	if r.edge != nil && r.consumers.Len() == 0 {
+		r.edge.OnIdle(time.Duration(r.vhost.edge_idle_timeout_ms) * time.Millisecond, r.on_edge_idle)
+	}
+}
+/**
+* when edge idle timeout, stop the ingest if still no player.
+*/
+func (r *SrsSource) on_edge_idle() {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	if r.consumers.Len() > 0 {
+		return
+	}
+	r.edge.Stop()
+}
+/**
+* the audio, video or data message from the publisher, checked under lock,
+* for the old publisher may still deliver the messages received before
+* it quit for takeover, which never mix into the stream of new publisher.
+*/
+func (r *SrsSource) OnPublisherMessage(client *SrsClient, msg *rtmp.Message) (err error) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	if r.publisher != client {
+		return SrsError{code:ERROR_SYSTEM_STREAM_BUSY, desc:"the publisher is takeover by another client"}
+	}
+
+	switch {
+	case msg.Header.IsAudio():
+		return r.on_audio(msg)
+	case msg.Header.IsVideo():
+		return r.on_video(msg)
+	case msg.Header.IsAmf0Data():
+		return r.on_metadata(msg)
+	}
+	return
+}
+/**
+* when got the onMetaData, cache it and copy to all consumers,
+* ignore the other data message.
+*/
+func (r *SrsSource) OnMetaData(msg *rtmp.Message) (err error) {
+	r.consumers_lock.Lock()
+	defer r.consumers_lock.Unlock()
+
+	return r.on_metadata(msg)
+}
+func (r *SrsSource) on_metadata(msg *rtmp.Message) (err error) {
+	r.kbps.OnRecv(len(msg.Payload))
+
+	var payload []byte
+	var metadata map[string]interface{}
+	if payload, metadata, err = srs_codec_decode_metadata(msg.Payload); err != nil || payload == nil {
+		return
+	}
+
+	if v, ok := metadata["audiosamplerate"].(float64); ok {
+		r.sample_rate = int(v)
+	}
+	if v, ok := metadata["framerate"].(float64); ok {
+		r.frame_rate = int(v)
+	}
+
+	// cache the onMetaData, the @setDataFrame is removed.
+	r.cache_metadata = msg.Copy()
+	r.cache_metadata.Payload = payload
+	r.cache_metadata.Header.PayloadLength = uint32(len(payload))
+	SrsTrace(r, r, "cache onMetaData, sample_rate=%v, frame_rate=%v", r.sample_rate, r.frame_rate)
+
+	// copy to all consumer
+	for p := r.consumers.Front(); p != nil; p = p.Next() {
+		p := p.Value.(*SrsConsumer)
+		if err = p.OnMessage(r.cache_metadata.Copy(), r.sample_rate, r.frame_rate); err != nil {
+			return
+		}
+	}
+	return
 }
 func (r *SrsSource) OnAudio(msg *rtmp.Message) (err error) {
 	r.consumers_lock.Lock()
 	defer r.consumers_lock.Unlock()
 
+	return r.on_audio(msg)
+}
+func (r *SrsSource) on_audio(msg *rtmp.Message) (err error) {
+	r.kbps.OnRecv(len(msg.Payload))
+
+	// cache the sequence header, the latest is used.
+	if srs_codec_audio_is_sequence_header(msg.Payload) {
+		r.cache_sh_audio = msg.Copy()
+	}
+
 	// SRS_HLS
-	// TODO: FIXME: implements it.
+	r.hls.OnAudio(msg)
+
+	// cache the last gop.
+	r.gop_cache.Cache(msg)
 
 	// copy to all consumer
 	for p := r.consumers.Front(); p != nil; p = p.Next() {
@@ -101,8 +629,21 @@ func (r *SrsSource) OnVideo(msg *rtmp.Message) (err error) {
 	r.consumers_lock.Lock()
 	defer r.consumers_lock.Unlock()
 
+	return r.on_video(msg)
+}
+func (r *SrsSource) on_video(msg *rtmp.Message) (err error) {
+	r.kbps.OnRecv(len(msg.Payload))
+
+	// cache the sequence header, the latest is used.
+	if srs_codec_video_is_sequence_header(msg.Payload) {
+		r.cache_sh_video = msg.Copy()
+	}
+
 	// SRS_HLS
-	// TODO: FIXME: implements it.
+	r.hls.OnVideo(msg)
+
+	// cache the last gop.
+	r.gop_cache.Cache(msg)
 
 	// copy to all consumer
 	for p := r.consumers.Front(); p != nil; p = p.Next() {
@@ -119,29 +660,251 @@ func (r *SrsSource) OnVideo(msg *rtmp.Message) (err error) {
 */
 type SrsConsumer struct {
 	source *SrsSource
-	msgs chan *rtmp.Message
 	elem *list.Element
+	// the lock for the queue, the source lock may be held when lock it.
+	lock *sync.Mutex
+	// the queue of messages, bounded by the duration and length.
+	msgs []*rtmp.Message
+	max_duration uint64
+	max_msgs int
+	// notify the reader that messages available, never block.
+	notify chan bool
+	// whether drop the media messages until the next keyframe.
+	wait_keyframe bool
+	// whether got video, the audio is keyframe for pure audio stream.
+	has_video bool
+	// the messages dropped when queue overflow.
+	dropped uint64
+	// whether the client paused, only keep the last gop when paused.
+	paused bool
+	// whether got keyframe when paused, the stale messages are dropped.
+	paused_has_keyframe bool
 }
 func NewSrsConsumer(source *SrsSource) (*SrsConsumer) {
 	r := &SrsConsumer{}
 	r.source = source
-	// TODO: FIXME: use buffered channel
-	r.msgs = make(chan *rtmp.Message, 1000)
+	r.lock = &sync.Mutex{}
+	r.max_duration = uint64(source.vhost.queue_duration_ms)
+	r.max_msgs = source.vhost.queue_length
+	r.notify = make(chan bool, 1)
 	return r
 }
-func (r *SrsConsumer) Messages() (chan *rtmp.Message) {
-	return r.msgs
+/**
+* the chan notified when messages available, use Fetch to get them.
+*/
+func (r *SrsConsumer) Wait() (chan bool) {
+	return r.notify
 }
+/**
+* fetch all messages in queue, empty the queue.
+*/
+func (r *SrsConsumer) Fetch() (msgs []*rtmp.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	msgs = r.msgs
+	r.msgs = nil
+	return
+}
+/**
+* get the count of messages in queue, the backlog to send.
+*/
+func (r *SrsConsumer) Len() (int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.msgs)
+}
+/**
+* get the count of messages dropped for queue overflow.
+*/
+func (r *SrsConsumer) Dropped() (uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.dropped
+}
+/**
+* push the message to queue, never block the source,
+* drop to the last keyframe when queue overflow.
+*/
 func (r *SrsConsumer) OnMessage(msg *rtmp.Message, tba int, tbv int) (err error) {
-	r.msgs <- msg
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if msg.Header.IsVideo() && !srs_consumer_is_header(msg) {
+		r.has_video = true
+	}
+	is_keyframe := r.is_keyframe(msg)
+
+	// when paused, the new gop starts with keyframe, drop the older one.
+	if r.paused && is_keyframe {
+		r.shrink()
+		r.paused_has_keyframe = true
+	}
+
+	// drop the media messages until keyframe, for the decoder to resume.
+	if r.wait_keyframe && !srs_consumer_is_header(msg) {
+		if !is_keyframe {
+			r.dropped++
+			return
+		}
+		r.wait_keyframe = false
+	}
+
+	r.msgs = append(r.msgs, msg)
+
+	if r.overflow() {
+		r.drop_to_keyframe()
+	}
+
+	select {
+	case r.notify <- true:
+	default:
+	}
 	return
 }
 /**
+* pause or unpause the consumer,
+* when unpause, the stale messages are dropped and resume from the last keyframe.
+*/
+func (r *SrsConsumer) Pause(is_pause bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.paused = is_pause; is_pause {
+		r.paused_has_keyframe = false
+		return
+	}
+
+	// no keyframe when paused, the queue is stale, wait for the next keyframe.
+	if !r.paused_has_keyframe {
+		r.shrink()
+		r.wait_keyframe = true
+	}
+	r.paused_has_keyframe = false
+
+	select {
+	case r.notify <- true:
+	default:
+	}
+}
+func (r *SrsConsumer) is_keyframe(msg *rtmp.Message) (bool) {
+	if srs_consumer_is_header(msg) {
+		return false
+	}
+	if msg.Header.IsVideo() {
+		return srs_codec_video_is_keyframe(msg.Payload)
+	}
+	// for pure audio stream, each audio is keyframe.
+	return msg.Header.IsAudio() && !r.has_video
+}
+/**
+* whether the duration or length of queue exceed the max.
+*/
+func (r *SrsConsumer) overflow() (bool) {
+	if len(r.msgs) > r.max_msgs {
+		return true
+	}
+
+	// the first and last audio or video message, except the sequence headers,
+	// which are kept with stale timestamp.
+	var first, last *rtmp.Message
+	for i := 0; i < len(r.msgs) && first == nil; i++ {
+		if msg := r.msgs[i]; !srs_consumer_is_header(msg) && (msg.Header.IsAudio() || msg.Header.IsVideo()) {
+			first = msg
+		}
+	}
+	for i := len(r.msgs) - 1; i >= 0 && last == nil; i-- {
+		if msg := r.msgs[i]; !srs_consumer_is_header(msg) && (msg.Header.IsAudio() || msg.Header.IsVideo()) {
+			last = msg
+		}
+	}
+
+	// ignore the timestamp jump back.
+	if first == nil || last.Header.Timestamp < first.Header.Timestamp {
+		return false
+	}
+	return last.Header.Timestamp - first.Header.Timestamp > r.max_duration
+}
+/**
+* drop the messages before the last keyframe, except the sequence headers and metadata,
+* wait for the next keyframe when no keyframe or the last gop still overflow.
+*/
+func (r *SrsConsumer) drop_to_keyframe() {
+	before := len(r.msgs)
+
+	last_keyframe := -1
+	for i, msg := range r.msgs {
+		if r.is_keyframe(msg) {
+			last_keyframe = i
+		}
+	}
+
+	// the gop from the last keyframe.
+	var gop []*rtmp.Message
+	if last_keyframe >= 0 {
+		gop = r.msgs[last_keyframe:]
+		r.msgs = r.msgs[:last_keyframe]
+	}
+
+	r.shrink()
+	r.msgs = append(r.msgs, gop...)
+	if gop == nil || r.overflow() {
+		r.shrink()
+		r.wait_keyframe = true
+	}
+
+	// the sequence headers and metadata kept are not dropped.
+	dropped := before - len(r.msgs)
+	r.dropped += uint64(dropped)
+	SrsWarn(r.source, r.source, "consumer queue overflow, drop %v messages, total dropped %v, wait keyframe=%v",
+		dropped, r.dropped, r.wait_keyframe)
+}
+/**
+* drop the messages in queue, except the last sequence headers and metadata.
+*/
+func (r *SrsConsumer) shrink() {
+	var metadata, sh_video, sh_audio *rtmp.Message
+	for _, msg := range r.msgs {
+		if msg.Header.IsAmf0Data() || msg.Header.IsAmf3Data() {
+			metadata = msg
+		} else if msg.Header.IsVideo() && srs_codec_video_is_sequence_header(msg.Payload) {
+			sh_video = msg
+		} else if msg.Header.IsAudio() && srs_codec_audio_is_sequence_header(msg.Payload) {
+			sh_audio = msg
+		}
+	}
+
+	r.msgs = nil
+	for _, msg := range []*rtmp.Message{ metadata, sh_video, sh_audio } {
+		if msg != nil {
+			r.msgs = append(r.msgs, msg)
+		}
+	}
+}
+/**
 * close the consumer, for example, client play another source.
  */
 func (r *SrsConsumer) Close() (err error) {
 	r.source.RemoveConsumer(r)
 	r.source = nil
-	close(r.msgs)
 	return
 }
+
+/**
+* whether the message is the sequence header or metadata,
+* which is required by decoder and never dropped.
+*/
+func srs_consumer_is_header(msg *rtmp.Message) (bool) {
+	if msg.Header.IsAmf0Data() || msg.Header.IsAmf3Data() {
+		return true
+	}
+	if msg.Header.IsVideo() {
+		return srs_codec_video_is_sequence_header(msg.Payload)
+	}
+	if msg.Header.IsAudio() {
+		return srs_codec_audio_is_sequence_header(msg.Payload)
+	}
+	return false
+}
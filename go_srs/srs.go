@@ -21,11 +21,24 @@
 
 package main
 
-//import "runtime"
+import (
+	"flag"
+	"os"
+	//"runtime"
+)
+
+// the config file, for example, ./conf/srs.conf
+var conf_file string
 
 func main() {
+	flag.StringVar(&conf_file, "c", "", "the config file, for example, ./conf/srs.conf")
+	flag.Parse()
+
 	//runtime.GOMAXPROCS(2)
 	r := NewSrsServer()
 	r.PrintInfo()
+	if err := r.Initialize(conf_file); err != nil {
+		os.Exit(1)
+	}
 	r.Serve()
 }